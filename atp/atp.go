@@ -34,29 +34,145 @@
 package atp
 
 import (
+	"context"
 	"fmt"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/trencat/goutils/syslog"
+	"github.com/trencat/train/atp/registry"
+	"github.com/trencat/train/atp/snapshot"
+	"github.com/trencat/train/atp/supervisor"
 	"github.com/trencat/train/core"
 )
 
 // api contains channels for get/set methods
 type api struct {
-	start       chan chan error
-	stop        chan struct{}
-	kill        chan struct{}
-	notifyOff   chan struct{}
-	getSensors  chan chan Sensors
-	setSetpoint chan core.Setpoint
-	setRoute    chan setRouteRequest
+	start          chan chan error
+	stop           chan struct{}
+	kill           chan struct{}
+	notifyOff      chan struct{}
+	getSensors     chan chan Sensors
+	setSetpoint    chan core.Setpoint
+	setRoute       chan setRouteRequest
+	warningTimeout chan struct{}
+	// escalate carries requests to force state from Shutdown to
+	// Alarm; see Escalate.
+	escalate chan struct{}
+	// reload carries ReloadRequests to apply route/timer tuning
+	// without a restart; see Reload.
+	reload chan reloadRequest
+	// registryState carries the latest State to the registry goroutine.
+	// It is drained and refilled whenever State changes (see
+	// publishRegistryState), so it never blocks the main loop; the
+	// registry goroutine only ever sees the most recent value, not
+	// every transition.
+	registryState chan State
+	// checkpoint carries requests for a snapshot.Snapshot of the
+	// current state, built and replied to from inside the main loop
+	// so it never races with it. Used by both Snapshot and
+	// checkpointRoutine.
+	checkpoint chan chan snapshotResponse
+}
+
+// snapshotResponse is the reply to a checkpoint request.
+type snapshotResponse struct {
+	data []byte
+	err  error
 }
 
 // Sensors contains core.Sensors data and ATP state
 type Sensors struct {
 	Sensors core.Sensors
 	State   State
+	// NextAlarm is how long remains before state escalates from
+	// Warning to Alarm, or zero if state is not Warning.
+	NextAlarm time.Duration
+	// Subsystems reports the last observed supervisor.Health of every
+	// Runnable the supervisor runs ("run", "registry", "checkpoint"),
+	// keyed by Spec.Name, so a caller can tell a crashed-and-restarted
+	// or escalated subsystem apart from State alone.
+	Subsystems map[string]supervisor.Health
+}
+
+// Options configures the timers Atp runs on. The zero value of each
+// field falls back to its default, so callers only need to set the
+// fields they want to override.
+type Options struct {
+	// RefreshRate is how often the main loop re-evaluates sensors and
+	// API requests. Defaults to 200ms.
+	RefreshRate time.Duration
+	// WarningTimeout is how long state may remain Warning before
+	// escalating to Alarm. Defaults to 5s.
+	WarningTimeout time.Duration
+	// SetpointTimeout is how long UpdateSensors may go without a
+	// renewed Setpoint before raising a Heartbeat alarm. Defaults to 5s.
+	SetpointTimeout time.Duration
+
+	// Registry, if non-nil, makes Atp register itself on New and
+	// re-announce periodically, so orchestration layers can discover
+	// active trains and route around ones in Warning/Alarm/Panic. A
+	// nil Registry (the default) disables this entirely.
+	Registry registry.Registry
+	// RegistryInterval is how often Atp re-announces itself to
+	// Registry. Defaults to 30s.
+	RegistryInterval time.Duration
+	// SensorsAddr is the address external clients should query for
+	// this train's Sensors, reported as Registry metadata. Atp does
+	// not itself serve that endpoint; it is up to the caller to run
+	// one, e.g. via transport/jsonrpc.
+	SensorsAddr string
+
+	// CheckpointStore, if non-nil, makes Atp periodically Snapshot
+	// itself and persist the result, so a supervisor can later Resume
+	// it across a restart without losing the Warning-to-Alarm
+	// escalation clock or an in-progress emergency brake. A nil
+	// CheckpointStore (the default) disables this entirely.
+	CheckpointStore snapshot.Store
+	// CheckpointInterval is how often Atp checkpoints itself to
+	// CheckpointStore. Defaults to 30s.
+	CheckpointInterval time.Duration
+}
+
+// Default timer values, used whenever the corresponding Options field
+// is left zero.
+const (
+	defaultRefreshRate        = 200 * time.Millisecond
+	defaultWarningTimeout     = 5 * time.Second
+	defaultSetpointTimeout    = 5 * time.Second
+	defaultRegistryInterval   = 30 * time.Second
+	defaultCheckpointInterval = 30 * time.Second
+)
+
+func (o Options) withDefaults() Options {
+	if o.RefreshRate <= 0 {
+		o.RefreshRate = defaultRefreshRate
+	}
+	if o.WarningTimeout <= 0 {
+		o.WarningTimeout = defaultWarningTimeout
+	}
+	if o.SetpointTimeout <= 0 {
+		o.SetpointTimeout = defaultSetpointTimeout
+	}
+	if o.RegistryInterval <= 0 {
+		o.RegistryInterval = defaultRegistryInterval
+	}
+	if o.CheckpointInterval <= 0 {
+		o.CheckpointInterval = defaultCheckpointInterval
+	}
+	return o
+}
+
+// parseOptions applies withDefaults to the first Options in opts, or
+// to the zero Options if opts is empty.
+func parseOptions(opts ...Options) Options {
+	var options Options
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	return options.withDefaults()
 }
 
 type setRouteRequest struct {
@@ -64,18 +180,98 @@ type setRouteRequest struct {
 	response chan error
 }
 
+// ReloadRequest carries route/timer overrides to apply via Reload
+// without restarting Atp. Route and a nil timer field leave the
+// corresponding current value unchanged.
+type ReloadRequest struct {
+	// Route, if non-nil, replaces the current route; see SetRoute.
+	Route []core.Track
+	// RefreshRate, WarningTimeout and SetpointTimeout, if non-nil,
+	// override the matching Options field.
+	RefreshRate     *time.Duration
+	WarningTimeout  *time.Duration
+	SetpointTimeout *time.Duration
+}
+
+type reloadRequest struct {
+	req      ReloadRequest
+	response chan error
+}
+
 // Atp implements interfaces.ATP.
 type Atp struct {
-	core         *core.Core
+	core          *core.Core
+	userSetpoint  core.Setpoint
+	setpoint      core.Setpoint
+	state         stateMachine
+	opts          Options
+	warningTimer  *time.Timer
+	alarmDeadline time.Time // zero if warningTimer is not armed
+	api           api
+	supervisor    *supervisor.Supervisor
+	cancel        context.CancelFunc
+	trainID       int
+	routeHash     string // TODO: does not track changes made via SetRoute
+
+	// lastRegistryState is the State last sent to registryRoutine via
+	// publishRegistryState, which only sends again once State
+	// actually changes; registryRoutine's own timer drives steady
+	// re-announce at RegistryInterval otherwise. Starts at Init (the
+	// zero value), a State no real run ever reaches, so the first
+	// call always sends.
+	lastRegistryState State
+
+	// checkpointStopped is closed once checkpointRoutine has returned,
+	// so offRoutine can wait for it before closing atp.api.checkpoint;
+	// see checkpointRoutine.
+	checkpointStopped chan struct{}
+}
+
+// New initialises an Atp instance. opts configures its timers; if
+// omitted, every Options field falls back to its default.
+func New(train core.Train, route []core.Track, sensors core.Sensors, opts ...Options) (*Atp, error) {
+	return newAtp(train, route, sensors, parseOptions(opts...), nil)
+}
+
+// Resume reconstructs an Atp from a Snapshot produced by Snapshot, so
+// a supervisor can restart the process without losing the
+// Warning-to-Alarm escalation clock or an in-progress emergency
+// brake. route is supplied the same way as in New: a Snapshot does
+// not duplicate route/track metadata the caller already has.
+func Resume(train core.Train, route []core.Track, data []byte, opts ...Options) (*Atp, error) {
+	snap, err := snapshot.Decode(data)
+	if err != nil {
+		return &Atp{}, err
+	}
+
+	seed := resumeSeed{
+		state:        State(snap.State),
+		prevState:    State(snap.PrevState),
+		userSetpoint: snap.UserSetpoint,
+		setpoint:     snap.Setpoint,
+		nextAlarm:    snap.NextAlarm,
+	}
+
+	return newAtp(train, route, snap.Sensors, parseOptions(opts...), &seed)
+}
+
+// resumeSeed carries the extra state Resume restores on top of what
+// newAtp derives from sensors alone: the exact state/prevState (which
+// may differ from what sensors.Warnings/Alarms would produce, e.g. a
+// Shutdown in progress), the pending Warning-to-Alarm deadline and the
+// setpoints a Snapshot captured.
+type resumeSeed struct {
+	state        State
+	prevState    State
 	userSetpoint core.Setpoint
 	setpoint     core.Setpoint
-	state        stateMachine
-	nextAlarm    time.Time // TODO: Review
-	api          api
+	nextAlarm    time.Duration
 }
 
-// New initialises an Atp instance.
-func New(train core.Train, route []core.Track, sensors core.Sensors) (*Atp, error) {
+// newAtp builds an Atp from train/route/sensors/options the way New
+// does. If seed is non-nil, its fields replace the state/setpoints
+// newAtp would otherwise derive from sensors, as Resume needs.
+func newAtp(train core.Train, route []core.Track, sensors core.Sensors, options Options, seed *resumeSeed) (*Atp, error) {
 	// Update setpoint time to prevent Heartbeat alarms
 	sensors.Setpoint.Time = time.Now()
 	sensors.Time = time.Now()
@@ -85,6 +281,7 @@ func New(train core.Train, route []core.Track, sensors core.Sensors) (*Atp, erro
 	if err != nil {
 		return &Atp{}, err
 	}
+	co.SetHeartbeatTimeout(options.SetpointTimeout)
 
 	state, err := newStateMachine()
 	if err != nil {
@@ -94,34 +291,106 @@ func New(train core.Train, route []core.Track, sensors core.Sensors) (*Atp, erro
 	atp := Atp{
 		core:     &co,
 		state:    state,
+		opts:     options,
 		setpoint: sensors.Setpoint,
 		api: api{
-			start:       make(chan chan error),
-			stop:        make(chan struct{}),
-			kill:        make(chan struct{}),
-			notifyOff:   make(chan struct{}),
-			getSensors:  make(chan chan Sensors),
-			setSetpoint: make(chan core.Setpoint),
-			setRoute:    make(chan setRouteRequest),
+			start:          make(chan chan error),
+			stop:           make(chan struct{}),
+			kill:           make(chan struct{}),
+			notifyOff:      make(chan struct{}),
+			getSensors:     make(chan chan Sensors),
+			setSetpoint:    make(chan core.Setpoint),
+			setRoute:       make(chan setRouteRequest),
+			warningTimeout: make(chan struct{}, 1),
+			escalate:       make(chan struct{}),
+			reload:         make(chan reloadRequest),
+			registryState:  make(chan State, 1),
+			checkpoint:     make(chan chan snapshotResponse),
 		},
-	}
-
-	if sensors.Warnings.Any() {
-		if err = atp.state.set(Warning); err != nil {
-			return &atp, err
+		trainID:           train.ID,
+		routeHash:         routeHash(route),
+		checkpointStopped: make(chan struct{}),
+	}
+
+	if seed != nil {
+		atp.state.state = seed.state
+		atp.state.prevState = seed.prevState
+		atp.userSetpoint = seed.userSetpoint
+		atp.setpoint = seed.setpoint
+		if seed.nextAlarm > 0 {
+			atp.armWarningTimer(seed.nextAlarm)
 		}
-	}
-	if sensors.Alarms.Any() {
-		if err = atp.state.set(Alarm); err != nil {
-			return &atp, err
+	} else {
+		if sensors.Warnings.Any() {
+			if err = atp.state.set(Warning); err != nil {
+				return &atp, err
+			}
+		}
+		if sensors.Alarms.Any() {
+			if err = atp.state.set(Alarm); err != nil {
+				return &atp, err
+			}
 		}
 	}
 
-	// Run
+	// Run the closed loop under a supervisor. Policy is Escalate so
+	// the default behavior is unchanged from before the supervisor
+	// existed: a crash puts state to Panic and brings the process
+	// down. Callers that want crashed subsystems restarted instead
+	// can build their own Atp-like Runnable around supervisor.Restart.
+	//
+	// run stays a single Runnable rather than one per state-machine
+	// step (onRoutine/activeRoutine/warningRoutine/alarmRoutine/
+	// shutdownRoutine) or per API listener (getRoutine/setRoutine/
+	// signalsRoutine): none of those take a lock, because they are
+	// only ever safe to call from the one goroutine that owns
+	// atp.state, atp.setpoint, atp.warningTimer and atp.alarmDeadline
+	// (see stateMachine's own "not safe, locks must be implemented
+	// somewhere else" comment). Restarting one of them independently
+	// would mean two goroutines touching that state concurrently,
+	// which needs those fields made concurrency-safe first, not just a
+	// new Spec. registryRoutine and checkpointRoutine are already
+	// split out because they only ever touch atp state through
+	// channels (atp.api.registryState, atp.api.checkpoint /
+	// Snapshot), never directly; getSensors reports supervisor.Health()
+	// for all three under Sensors.Subsystems, so a crash-and-restart or
+	// escalation on any of them is at least observable even where the
+	// loop itself cannot be split further.
+	ctx, cancel := context.WithCancel(context.Background())
+	atp.cancel = cancel
+	atp.supervisor = supervisor.New()
+
 	notify := make(chan struct{})
-	go atp.run(notify)
+	atp.supervisor.Add(ctx, supervisor.Spec{
+		Name:   "run",
+		Policy: supervisor.RestartPolicyEscalate,
+		Run: func(ctx context.Context) error {
+			return atp.run(notify)
+		},
+	})
 	<-notify // Wait until go routine starts
 
+	// Registering is best-effort and must never hold up New or the
+	// main loop: it runs in its own goroutine, talking to it only
+	// through atp.api.registryState. A nil Registry makes it exit
+	// straight away.
+	atp.supervisor.Add(ctx, supervisor.Spec{
+		Name: "registry",
+		Run:  atp.registryRoutine,
+	})
+
+	// Checkpointing is likewise best-effort and talks to the main loop
+	// only through atp.api.checkpoint, via Snapshot. A nil
+	// CheckpointStore makes it exit straight away. Policy is Escalate,
+	// not the default Restart: checkpointRoutine closes the one-shot
+	// atp.checkpointStopped on every return (see checkpointRoutine), so
+	// a restarted instance would panic on an already-closed channel.
+	atp.supervisor.Add(ctx, supervisor.Spec{
+		Name:   "checkpoint",
+		Policy: supervisor.RestartPolicyEscalate,
+		Run:    atp.checkpointRoutine,
+	})
+
 	log.Info("New ATP initialised")
 
 	return &atp, nil
@@ -132,7 +401,7 @@ func (atp *Atp) Sensors() Sensors {
 	select {
 	case <-atp.api.notifyOff:
 		// atp has finished running
-		return Sensors{Sensors: atp.core.Sensors(), State: atp.state.get()}
+		return Sensors{Sensors: atp.core.Sensors(), State: atp.state.get(), Subsystems: atp.supervisor.Health()}
 	default:
 		ch := make(chan Sensors)
 		defer close(ch)
@@ -174,36 +443,73 @@ func (atp *Atp) SetRoute(route []core.Track) error {
 	}
 }
 
+// Snapshot captures state, setpoints, the Warning-to-Alarm escalation
+// clock and core.Sensors into the versioned binary encoding defined
+// by atp/snapshot, so a later Resume can reconstruct an equivalent
+// Atp across a process restart. Calling this method when state is
+// Off returns an error.
+//
+// Unlike the other API methods in this file, the notifyOff check and
+// the send below are a single select rather than a check-then-send:
+// checkpointRoutine can call Snapshot concurrently with offRoutine
+// tearing down, and a plain send racing offRoutine's close of
+// atp.api.checkpoint would panic. Since offRoutine closes notifyOff
+// before it ever closes atp.api.checkpoint, and nothing receives on
+// atp.api.checkpoint once Off is reached, folding the send into the
+// same select guarantees a Snapshot call still in flight at that point
+// takes the notifyOff case instead of ever reaching the send.
+func (atp *Atp) Snapshot() ([]byte, error) {
+	ch := make(chan snapshotResponse)
+	defer close(ch)
+
+	select {
+	case <-atp.api.notifyOff:
+		return nil, errors.New("Attempt to Snapshot an atp that has finished running")
+	case atp.api.checkpoint <- ch:
+		resp := <-ch
+		return resp.data, resp.err
+	}
+}
+
 // run starts the atp closed loop algorithm. It is divided in three steps:
 // the operations step, where Sensors and status values are updated,
 // get/set step, where get and set queries are performed and signal step,
 // where signals are listened and processed. This three-steps implementation
 // avoid the use of locks on common data, since there no two threads accessing
-// the same data concurrently.
-func (atp *Atp) run(notify chan struct{}) {
+// the same data concurrently. run returns supervisor.ErrDone on a clean
+// Off exit, or the error that put state to Panic otherwise, so its
+// supervisor.Supervisor can tell the two apart.
+func (atp *Atp) run(notify chan struct{}) error {
 	// Notify run already started
 	close(notify)
 
-loop:
 	for {
 		// Operations
+		var err error
 		switch atp.state.get() {
 		case On:
-			atp.onRoutine()
+			err = atp.onRoutine()
 		case Active:
-			atp.activeRoutine()
+			err = atp.activeRoutine()
 		case Warning:
-			atp.warningRoutine()
+			err = atp.warningRoutine()
 		case Alarm:
-			atp.alarmRoutine()
+			err = atp.alarmRoutine()
 		case Shutdown:
-			if done := atp.shutdownRoutine(); done {
+			done, serr := atp.shutdownRoutine()
+			err = serr
+			if err == nil && done {
 				atp.state.set(Off)
 				continue
 			}
 		case Off:
 			atp.offRoutine()
-			break loop
+			return supervisor.ErrDone
+		}
+
+		if err != nil {
+			atp.state.set(Panic)
+			return err
 		}
 
 		// API Getters and setters
@@ -213,8 +519,10 @@ loop:
 		// API start/stop/kill signals
 		atp.signalsRoutine()
 
-		// TODO: Remove hardcoded constant
-		time.Sleep(time.Duration(200) * time.Millisecond)
+		// Publish current state for the registry goroutine to pick up.
+		atp.publishRegistryState()
+
+		time.Sleep(atp.opts.RefreshRate)
 	}
 }
 
@@ -226,10 +534,45 @@ func (atp *Atp) getRoutine() {
 	default:
 		break
 	}
+
+	// Get snapshot
+	select {
+	case ch := <-atp.api.checkpoint:
+		ch <- atp.buildSnapshot()
+	default:
+		break
+	}
 }
 
 func (atp *Atp) getSensors() Sensors {
-	return Sensors{Sensors: atp.core.Sensors(), State: atp.state.get()}
+	var nextAlarm time.Duration
+	if atp.state.get() == Warning && !atp.alarmDeadline.IsZero() {
+		nextAlarm = time.Until(atp.alarmDeadline)
+	}
+	return Sensors{
+		Sensors:    atp.core.Sensors(),
+		State:      atp.state.get(),
+		NextAlarm:  nextAlarm,
+		Subsystems: atp.supervisor.Health(),
+	}
+}
+
+func (atp *Atp) buildSnapshot() snapshotResponse {
+	var nextAlarm time.Duration
+	if atp.state.get() == Warning && !atp.alarmDeadline.IsZero() {
+		nextAlarm = time.Until(atp.alarmDeadline)
+	}
+
+	data, err := snapshot.Encode(snapshot.Snapshot{
+		Version:      snapshot.Version,
+		State:        int8(atp.state.get()),
+		PrevState:    int8(atp.state.prev()),
+		UserSetpoint: atp.userSetpoint,
+		Setpoint:     atp.setpoint,
+		NextAlarm:    nextAlarm,
+		Sensors:      atp.core.Sensors(),
+	})
+	return snapshotResponse{data: data, err: err}
 }
 
 func (atp *Atp) setRoutine() {
@@ -250,6 +593,35 @@ func (atp *Atp) setRoutine() {
 	default:
 		break
 	}
+
+	// Reload route/timer tuning
+	select {
+	case request := <-atp.api.reload:
+		request.response <- atp.applyReload(request.req)
+	default:
+		break
+	}
+}
+
+// applyReload applies req's overrides in place, leaving any zero
+// field at its current value.
+func (atp *Atp) applyReload(req ReloadRequest) error {
+	if req.Route != nil {
+		if err := atp.core.SetRoute(req.Route); err != nil {
+			return err
+		}
+	}
+	if req.RefreshRate != nil {
+		atp.opts.RefreshRate = *req.RefreshRate
+	}
+	if req.WarningTimeout != nil {
+		atp.opts.WarningTimeout = *req.WarningTimeout
+	}
+	if req.SetpointTimeout != nil {
+		atp.opts.SetpointTimeout = *req.SetpointTimeout
+		atp.core.SetHeartbeatTimeout(*req.SetpointTimeout)
+	}
+	return nil
 }
 
 func (atp *Atp) signalsRoutine() {
@@ -263,15 +635,24 @@ func (atp *Atp) signalsRoutine() {
 		}
 	case <-atp.api.kill:
 		atp.state.set(Off)
+	case <-atp.api.warningTimeout:
+		if atp.state.get() == Warning {
+			atp.state.set(Alarm)
+			atp.alarmDeadline = time.Time{}
+			atp.warningTimer = nil
+		}
+	case <-atp.api.escalate:
+		if atp.state.get() == Shutdown {
+			atp.state.set(Alarm)
+		}
 	default:
 	}
 }
 
-func (atp *Atp) onRoutine() {
+func (atp *Atp) onRoutine() error {
 	sensors, err := atp.updateSensors()
 	if err != nil {
-		atp.state.set(Panic)
-		panic(fmt.Sprintf("%+v", err))
+		return err
 	}
 
 	if sensors.Warnings.Any() {
@@ -281,68 +662,89 @@ func (atp *Atp) onRoutine() {
 	if sensors.Alarms.Any() {
 		atp.state.set(Alarm)
 	}
+
+	return nil
 }
 
-func (atp *Atp) activeRoutine() {
+func (atp *Atp) activeRoutine() error {
 	atp.setpoint = atp.userSetpoint
-	atp.onRoutine()
+	return atp.onRoutine()
 }
 
-func (atp *Atp) warningRoutine() {
-	atp.activeRoutine()
+func (atp *Atp) warningRoutine() error {
+	if err := atp.activeRoutine(); err != nil {
+		return err
+	}
 
 	// Check state is still Warning.
 	if atp.state.get() != Warning {
-		return
+		return nil
 	}
 
 	sensors := atp.core.Sensors()
-	// Activate/Deactivate next alarm trigger from warnings
+	// Arm/disarm the Warning-to-Alarm escalation timer from warnings.
 	if sensors.Warnings.Any() {
-		if atp.nextAlarm.IsZero() {
-			atp.nextAlarm = time.Now().Add(time.Duration(5) * time.Second)
+		if atp.warningTimer == nil {
+			atp.armWarningTimer(atp.opts.WarningTimeout)
 		}
 	} else {
-		if !atp.nextAlarm.IsZero() {
-			atp.nextAlarm = time.Time{}
-		}
+		atp.disarmWarningTimer()
 
 		// Set state before Warning
 		if prev := atp.state.prev(); prev == On || prev == Active {
 			atp.state.set(prev)
-			return
 		}
 	}
 
-	// Trigger alarm
-	if !atp.nextAlarm.IsZero() && time.Since(atp.nextAlarm) > 0 {
-		atp.state.set(Alarm)
-		atp.nextAlarm = time.Time{}
+	return nil
+}
+
+// armWarningTimer arms the Warning-to-Alarm escalation timer to fire
+// after d, replacing any timer already running.
+func (atp *Atp) armWarningTimer(d time.Duration) {
+	atp.alarmDeadline = time.Now().Add(d)
+	atp.warningTimer = time.AfterFunc(d, func() {
+		select {
+		case atp.api.warningTimeout <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// disarmWarningTimer stops the Warning-to-Alarm escalation timer, if armed.
+func (atp *Atp) disarmWarningTimer() {
+	if atp.warningTimer != nil {
+		atp.warningTimer.Stop()
+		atp.warningTimer = nil
 	}
+	atp.alarmDeadline = time.Time{}
 }
 
-func (atp *Atp) alarmRoutine() {
+func (atp *Atp) alarmRoutine() error {
+	// Drop any Warning-to-Alarm timer left over from a Warning episode
+	// that reached Alarm some other way, so the next episode re-arms it.
+	atp.disarmWarningTimer()
+
 	// Trigger emergency brake
 	atp.setpoint = atp.core.EmergencyBrakeSetpoint()
 
 	sensors, err := atp.updateSensors()
 	if err != nil {
-		atp.state.set(Panic)
-		panic(fmt.Sprintf("%+v", err))
+		return err
 	}
 
 	if !Stopped(sensors) {
-		return
+		return nil
 	}
 
 	atp.state.set(On)
+	return nil
 }
 
-func (atp *Atp) shutdownRoutine() bool {
+func (atp *Atp) shutdownRoutine() (bool, error) {
 	sensors, err := atp.updateSensors()
 	if err != nil {
-		atp.state.set(Panic)
-		panic(fmt.Sprintf("%+v", err))
+		return false, err
 	}
 
 	if !Stopped(sensors) {
@@ -350,21 +752,39 @@ func (atp *Atp) shutdownRoutine() bool {
 		if atp.state.get() != Alarm {
 			atp.state.set(Alarm)
 		}
-		return false
+		return false, nil
 	}
 
-	return true
+	return true, nil
 }
 
 func (atp *Atp) offRoutine() {
+	atp.cancel() // also deregisters from atp.opts.Registry, if any; see registryRoutine
+	atp.disarmWarningTimer()
+	// Undo any InstallSignalHandler registration for these specific
+	// signals, so it does not leak across instances (e.g. across test
+	// runs). A bare signal.Reset() would also clobber unrelated
+	// signal.Notify registrations elsewhere in the process, so it is
+	// scoped to exactly the signals InstallSignalHandler uses.
+	signal.Reset(syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT)
+
 	close(atp.api.notifyOff)
 	close(atp.api.start)
 	close(atp.api.stop)
 	close(atp.api.kill)
 	close(atp.api.setSetpoint)
 	close(atp.api.setRoute)
+	close(atp.api.escalate)
+	close(atp.api.reload)
 	close(atp.api.getSensors)
 
+	// Wait for checkpointRoutine to be done calling Snapshot before
+	// closing atp.api.checkpoint: notifyOff is already closed above,
+	// which unblocks any Snapshot call checkpointRoutine has in
+	// flight, so this returns promptly (see checkpointRoutine).
+	<-atp.checkpointStopped
+	close(atp.api.checkpoint)
+
 	// Empty api channels
 	for range atp.api.start {
 	}
@@ -376,9 +796,17 @@ func (atp *Atp) offRoutine() {
 	}
 	for range atp.api.setRoute {
 	}
+	for range atp.api.escalate {
+	}
+	for request := range atp.api.reload {
+		request.response <- errors.New("Attempt to Reload an atp that has finished running")
+	}
 	for ch := range atp.api.getSensors {
 		ch <- atp.getSensors()
 	}
+	for ch := range atp.api.checkpoint {
+		ch <- atp.buildSnapshot()
+	}
 }
 
 func (atp *Atp) startSignalRoutine() error {
@@ -444,6 +872,47 @@ func (atp *Atp) Kill() {
 	}
 }
 
+// Escalate forces state from Shutdown to Alarm, triggering the
+// emergency brake, for operators that need to force-stop a train
+// stuck trying to shut down gracefully. Calling this method when
+// state is not Shutdown, or is Off, takes no effect.
+//
+// For a moving train, shutdownRoutine already performs this same
+// Shutdown-to-Alarm transition on its own, on the very next tick that
+// finds the train not Stopped, so Escalate rarely beats it to the
+// punch. It is kept as an explicit, independent API rather than folded
+// away: it is the operator's forced-alarm entry point regardless of
+// shutdownRoutine's own escalation logic. TestInstallSignalHandlerEscalate
+// exercises the second-signal path that calls it, but on a moving train
+// it cannot tell Escalate's Alarm apart from shutdownRoutine's.
+func (atp *Atp) Escalate() {
+	select {
+	case <-atp.api.notifyOff:
+		// atp has finished running
+		return
+	default:
+		atp.api.escalate <- struct{}{}
+	}
+}
+
+// Reload applies req's Route and timer overrides without restarting
+// atp. Fields left at their zero value keep their current value.
+// Calling this method when state is Off takes no effect and a nil
+// error is returned.
+func (atp *Atp) Reload(req ReloadRequest) error {
+	select {
+	case <-atp.api.notifyOff:
+		// atp has finished running
+		return nil
+	default:
+		errch := make(chan error)
+		defer close(errch)
+
+		atp.api.reload <- reloadRequest{req: req, response: errch}
+		return <-errch
+	}
+}
+
 // Stopped returns true if the train is completely stopped.
 func Stopped(sensors core.Sensors) bool {
 	return (sensors.Velocity < 0.01 && sensors.Acceleration < 0.01)
@@ -4,26 +4,45 @@ import (
 	"fmt"
 	"log/syslog"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	log "github.com/trencat/goutils/syslog"
 	"github.com/trencat/train/atp"
+	"github.com/trencat/train/atp/registry"
+	"github.com/trencat/train/atp/supervisor"
 	"github.com/trencat/train/core"
 	"github.com/trencat/train/testutils"
 )
 
-var refreshRate time.Duration     // TODO: Remove from here and read from environ variable
-var setpointTimeout time.Duration // TODO: Remove from here and read from environ variable
-var warningTimeout time.Duration  // TODO: Remove from here and read from environ variable
+// refreshRate is how long tests sleep to let one tick of Atp's main
+// loop elapse; it is generous relative to testOptions.RefreshRate
+// (left at its default) to leave headroom for scheduling jitter.
+// setpointTimeout and warningTimeout are how long tests sleep waiting
+// for a Heartbeat or Warning-to-Alarm escalation. testOptions sets the
+// matching timeouts 2*refreshRate shorter, since the alarm is only
+// raised on a main loop tick after its timer, which starts later than
+// the sleep does, has run out.
+var refreshRate time.Duration
+var setpointTimeout time.Duration
+var warningTimeout time.Duration
+
+// testOptions is the atp.Options passed to every Atp built in this file.
+var testOptions atp.Options
 
 func TestMain(m *testing.M) {
 	// Parse args
-	// TODO: Read refreshRate from environ vars.
+	// TODO: Read these from environ vars.
 	refreshRate = time.Duration(1) * time.Second
 	setpointTimeout = time.Duration(7) * time.Second
 	warningTimeout = time.Duration(7) * time.Second
 
+	testOptions = atp.Options{
+		SetpointTimeout: setpointTimeout - 2*refreshRate,
+		WarningTimeout:  warningTimeout - 2*refreshRate,
+	}
+
 	// Setup
 	err := log.SetLogger("tcp", "localhost", "514",
 		syslog.LOG_WARNING|syslog.LOG_LOCAL0, "atpTest")
@@ -41,7 +60,7 @@ func TestMain(m *testing.M) {
 func TestOn(t *testing.T) {
 	alias := "stationary_flat"
 	scenario := testutils.GetScenario(alias, t)
-	Atp := testutils.NewAtp(scenario, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
 
 	Atp.Stop()
 	time.Sleep(refreshRate)
@@ -52,12 +71,39 @@ func TestOn(t *testing.T) {
 	}
 }
 
+// TestSensorsSubsystems tests that Sensors reports supervisor.Health
+// for every supervised subsystem ("run", "registry", "checkpoint"),
+// not just the FSM State: once Off, "run" reports Done (offRoutine
+// returned supervisor.ErrDone), and "registry"/"checkpoint" report
+// Done too (a nil Registry/CheckpointStore makes both exit straight
+// away on startup).
+func TestSensorsSubsystems(t *testing.T) {
+	alias := "stationary_flat"
+	scenario := testutils.GetScenario(alias, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
+
+	Atp.Stop()
+	time.Sleep(refreshRate)
+
+	subsystems := Atp.Sensors().Subsystems
+	for _, name := range []string{"run", "registry", "checkpoint"} {
+		health, ok := subsystems[name]
+		if !ok {
+			t.Errorf("Got no Health for subsystem %q", name)
+			continue
+		}
+		if health.Status != supervisor.Done {
+			t.Errorf("Subsystem %q: Got Status %d, expected Done", name, health.Status)
+		}
+	}
+}
+
 // TestStartError tests atp.Start() method returns error when called
 // before method atp.Set.
 func TestStartError(t *testing.T) {
 	alias := "stationary_flat"
 	scenario := testutils.GetScenario(alias, t)
-	Atp := testutils.NewAtp(scenario, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
 	defer Atp.Kill()
 
 	if err := Atp.Start(); err == nil {
@@ -69,7 +115,7 @@ func TestStartError(t *testing.T) {
 func TestActive(t *testing.T) {
 	alias := "stationary_flat"
 	scenario := testutils.GetScenario(alias, t)
-	Atp := testutils.NewAtp(scenario, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
 	defer Atp.Kill()
 
 	Atp.SetSetpoint(scenario.Sensors.Setpoint)
@@ -86,7 +132,7 @@ func TestActive(t *testing.T) {
 func TestActiveMovement(t *testing.T) {
 	alias := "stationary_flat"
 	scenario := testutils.GetScenario(alias, t)
-	Atp := testutils.NewAtp(scenario, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
 	defer Atp.Kill()
 
 	before := Atp.Sensors().Sensors
@@ -109,7 +155,7 @@ func TestActiveMovement(t *testing.T) {
 func TestActiveMovementStop(t *testing.T) {
 	alias := "moving_flat"
 	scenario := testutils.GetScenario(alias, t)
-	Atp := testutils.NewAtp(scenario, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
 	defer Atp.Kill()
 
 	Atp.SetSetpoint(scenario.Sensors.Setpoint)
@@ -128,7 +174,7 @@ func TestActiveMovementStop(t *testing.T) {
 func TestActiveStop(t *testing.T) {
 	alias := "stationary_flat"
 	scenario := testutils.GetScenario(alias, t)
-	Atp := testutils.NewAtp(scenario, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
 	defer Atp.Kill()
 
 	Atp.SetSetpoint(scenario.Sensors.Setpoint)
@@ -149,7 +195,7 @@ func TestActiveStop(t *testing.T) {
 func TestActiveVelocityOverrun(t *testing.T) {
 	alias := "velocity_limit"
 	scenario := testutils.GetScenario(alias, t)
-	Atp := testutils.NewAtp(scenario, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
 	defer Atp.Kill()
 
 	Atp.SetSetpoint(core.Setpoint{Value: 0.15, Time: time.Now()})
@@ -175,7 +221,7 @@ func TestActiveVelocityOverrun(t *testing.T) {
 func TestActiveAccelerationOOB(t *testing.T) {
 	alias := "stationary_ascend"
 	scenario := testutils.GetScenario(alias, t)
-	Atp := testutils.NewAtp(scenario, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
 	defer Atp.Kill()
 
 	Atp.SetSetpoint(core.Setpoint{Value: 10, Time: time.Now()})
@@ -193,7 +239,7 @@ func TestActiveAccelerationOOB(t *testing.T) {
 func TestSetpointTimeout(t *testing.T) {
 	alias := "velocity_limit"
 	scenario := testutils.GetScenario(alias, t)
-	Atp := testutils.NewAtp(scenario, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
 	defer Atp.Kill()
 
 	Atp.SetSetpoint(core.Setpoint{Value: 0, Time: time.Now()})
@@ -211,7 +257,7 @@ func TestSetpointTimeout(t *testing.T) {
 func TestWarningAlarm(t *testing.T) {
 	alias := "velocity_limit"
 	scenario := testutils.GetScenario(alias, t)
-	Atp := testutils.NewAtp(scenario, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
 	defer Atp.Kill()
 
 	Atp.SetSetpoint(core.Setpoint{Value: 0.1, Time: time.Now()})
@@ -224,12 +270,56 @@ func TestWarningAlarm(t *testing.T) {
 	}
 }
 
+// TestWarningAlarmRearm tests that the Warning-to-Alarm escalation
+// timer re-arms on a second Warning episode, not just the first: once
+// an episode fires the timer and Alarm brakes the train back to On,
+// running over the limit again must still escalate to Alarm after
+// warningTimeout, rather than staying stuck in Warning forever.
+func TestWarningAlarmRearm(t *testing.T) {
+	alias := "velocity_limit"
+	scenario := testutils.GetScenario(alias, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
+	defer Atp.Kill()
+
+	Atp.SetSetpoint(core.Setpoint{Value: 0.1, Time: time.Now()})
+	Atp.Start()
+	time.Sleep(warningTimeout)
+
+	if state := Atp.Sensors().State; state != atp.Alarm {
+		t.Fatalf("With scenario %s, Got state %d, Expected %d (first episode)",
+			alias, state, atp.Alarm)
+	}
+
+	// Wait until the emergency brake fully stops the train, returning
+	// state to On.
+	for {
+		Atp.SetSetpoint(core.Setpoint{Value: 0.1, Time: time.Now()})
+		time.Sleep(refreshRate)
+		if atp.Stopped(Atp.Sensors().Sensors) {
+			break
+		}
+	}
+	time.Sleep(refreshRate)
+	if state := Atp.Sensors().State; state != atp.On {
+		t.Fatalf("With scenario %s, Got state %d, Expected %d (back to On)",
+			alias, state, atp.On)
+	}
+
+	Atp.SetSetpoint(core.Setpoint{Value: 0.1, Time: time.Now()})
+	time.Sleep(warningTimeout)
+
+	if state := Atp.Sensors().State; state != atp.Alarm {
+		t.Errorf("With scenario %s, Got state %d, Expected %d (second episode)",
+			alias, state, atp.Alarm)
+	}
+}
+
 // TestAlarmSetpoints tests that setpoint is ignored if state is
 // set to Alarm, train stops completely and state changes to On.
 func TestAlarm(t *testing.T) {
 	alias := "velocity_limit_alarm"
 	scenario := testutils.GetScenario(alias, t)
-	Atp := testutils.NewAtp(scenario, t)
+	Atp := testutils.NewAtp(scenario, t, testOptions)
 	defer Atp.Kill()
 
 	Atp.SetSetpoint(scenario.Sensors.Setpoint)
@@ -269,6 +359,149 @@ func TestAlarm(t *testing.T) {
 	}
 }
 
+// TestRegistry tests atp registers itself with a Registry on startup
+// and deregisters once it reaches Off.
+func TestRegistry(t *testing.T) {
+	alias := "stationary_flat"
+	scenario := testutils.GetScenario(alias, t)
+	train := testutils.GetTrain(scenario.Train, t)
+
+	reg := registry.NewMemRegistry()
+	opts := testOptions
+	opts.Registry = reg
+
+	Atp := testutils.NewAtp(scenario, t, opts)
+	defer Atp.Kill()
+
+	time.Sleep(refreshRate)
+	got, ok := reg.Get(train.ID)
+	if !ok {
+		t.Fatalf("Train %d was not registered", train.ID)
+	}
+	if got.Health != registry.Passing {
+		t.Errorf("Got health %s, expected %s", got.Health, registry.Passing)
+	}
+
+	Atp.Stop()
+	time.Sleep(refreshRate)
+
+	if _, ok := reg.Get(train.ID); ok {
+		t.Errorf("Train %d is still registered after shutdown", train.ID)
+	}
+}
+
+// countingRegistry wraps a Registry and counts Register calls, so
+// TestRegistryDedup can tell whether publishRegistryState is
+// announcing on every main loop tick rather than only on actual State
+// changes.
+type countingRegistry struct {
+	registry.Registry
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *countingRegistry) Register(reg registry.Registration) error {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+	return c.Registry.Register(reg)
+}
+
+func (c *countingRegistry) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// TestRegistryDedup tests that publishRegistryState only re-announces
+// when State actually changes: once startup (registryRoutine's own
+// first announce, plus the one State sync publishRegistryState always
+// sends on its very first tick) has settled, a RegistryInterval much
+// longer than the test means Register should see no further calls no
+// matter how many main loop ticks elapse without a State change.
+func TestRegistryDedup(t *testing.T) {
+	alias := "stationary_flat"
+	scenario := testutils.GetScenario(alias, t)
+
+	reg := &countingRegistry{Registry: registry.NewMemRegistry()}
+	opts := testOptions
+	opts.Registry = reg
+	opts.RegistryInterval = time.Hour
+
+	Atp := testutils.NewAtp(scenario, t, opts)
+	defer Atp.Kill()
+
+	time.Sleep(refreshRate)
+	before := reg.Calls()
+
+	time.Sleep(3 * refreshRate)
+
+	if after := reg.Calls(); after != before {
+		t.Errorf("Got %d Register calls after startup settled with no State change, expected %d (no growth)",
+			after, before)
+	}
+}
+
+// TestSnapshotResume tests that an Atp built from Resume picks up the
+// same State and Sensors a prior Atp's Snapshot recorded. The scenario
+// is left stationary with no setpoint, so Position does not drift
+// between taking the Snapshot and reading resumed.Sensors().
+func TestSnapshotResume(t *testing.T) {
+	alias := "stationary_flat"
+	scenario := testutils.GetScenario(alias, t)
+	train := testutils.GetTrain(scenario.Train, t)
+	route := testutils.GetRoute(scenario.Route, t)
+
+	Atp := testutils.NewAtp(scenario, t, testOptions)
+
+	before := Atp.Sensors()
+	data, err := Atp.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot returned error %+v", err)
+	}
+	Atp.Kill()
+
+	resumed, err := atp.Resume(train, route, data, testOptions)
+	if err != nil {
+		t.Fatalf("Resume returned error %+v", err)
+	}
+	defer resumed.Kill()
+
+	after := resumed.Sensors()
+	if after.State != before.State {
+		t.Errorf("Got state %d, expected %d", after.State, before.State)
+	}
+	if after.Sensors.Position != before.Sensors.Position {
+		t.Errorf("Got position %f, expected %f", after.Sensors.Position, before.Sensors.Position)
+	}
+}
+
+// noopStore is a snapshot.Store that discards everything it is asked
+// to Save, used to drive checkpointRoutine without touching disk.
+type noopStore struct{}
+
+func (noopStore) Save(data []byte) error { return nil }
+
+// TestCheckpointStopRace tests that checkpointRoutine calling Snapshot
+// concurrently with Stop reaching Off does not panic: a tiny
+// CheckpointInterval keeps checkpointRoutine ticking constantly, so
+// some of those ticks land in the teardown window offRoutine closes
+// atp.api.checkpoint in (see Snapshot's doc comment).
+func TestCheckpointStopRace(t *testing.T) {
+	alias := "stationary_flat"
+	scenario := testutils.GetScenario(alias, t)
+
+	opts := testOptions
+	opts.CheckpointStore = noopStore{}
+	opts.CheckpointInterval = time.Millisecond
+
+	for i := 0; i < 20; i++ {
+		Atp := testutils.NewAtp(scenario, t, opts)
+		Atp.Stop()
+		time.Sleep(refreshRate)
+	}
+}
+
 // TestPanicOutOfRails tests that train panics when running out of
 // rails.
 func TestPanicOutOfRails(t *testing.T) {
@@ -0,0 +1,51 @@
+package atp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/trencat/goutils/syslog"
+	"github.com/trencat/train/atp/supervisor"
+)
+
+// checkpointRoutine periodically Snapshots atp and persists the
+// result to atp.opts.CheckpointStore, once every
+// atp.opts.CheckpointInterval. It returns supervisor.ErrDone once ctx
+// is cancelled. A nil CheckpointStore makes it a no-op.
+//
+// atp.checkpointStopped is closed right before returning, however
+// checkpointRoutine exits, so offRoutine can wait for this goroutine
+// to be done calling Snapshot before it closes atp.api.checkpoint.
+func (atp *Atp) checkpointRoutine(ctx context.Context) error {
+	defer close(atp.checkpointStopped)
+
+	store := atp.opts.CheckpointStore
+	if store == nil {
+		return supervisor.ErrDone
+	}
+
+	tick := make(chan struct{}, 1)
+	timer := time.AfterFunc(atp.opts.CheckpointInterval, func() {
+		select {
+		case tick <- struct{}{}:
+		default:
+		}
+	})
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return supervisor.ErrDone
+		case <-tick:
+			data, err := atp.Snapshot()
+			if err != nil {
+				log.Warning(fmt.Sprintf("%+v", err))
+			} else if err := store.Save(data); err != nil {
+				log.Warning(fmt.Sprintf("%+v", err))
+			}
+			timer.Reset(atp.opts.CheckpointInterval)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package atp
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	log "github.com/trencat/goutils/syslog"
+	"github.com/trencat/train/atp/registry"
+	"github.com/trencat/train/atp/supervisor"
+	"github.com/trencat/train/core"
+)
+
+// routeHash fingerprints a route so Registry consumers can tell two
+// Atp instances apart even when TrainID collides, without shipping
+// the whole route as metadata.
+func routeHash(route []core.Track) string {
+	h := fnv.New64a()
+	for _, track := range route {
+		fmt.Fprintf(h, "%d:%f:%f:%f:%f:%v;",
+			track.ID, track.Length, track.MaxVelocity, track.Slope, track.BendRadius, track.Tunnel)
+	}
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+// healthFor maps a State to the Consul-style health Registry expects,
+// so orchestration layers can route around trains that are not
+// passing.
+func healthFor(state State) registry.Health {
+	switch state {
+	case On, Active:
+		return registry.Passing
+	case Warning, Shutdown:
+		return registry.Warning
+	default: // Alarm, Panic, Off
+		return registry.Critical
+	}
+}
+
+// publishRegistryState hands the current State to registryRoutine
+// without blocking the main loop, but only when it differs from
+// atp.lastRegistryState: registryRoutine's own timer already
+// re-announces at RegistryInterval, so resending an unchanged State
+// on every main loop tick would announce far more often than that.
+func (atp *Atp) publishRegistryState() {
+	state := atp.state.get()
+	if state == atp.lastRegistryState {
+		return
+	}
+	atp.lastRegistryState = state
+
+	select {
+	case <-atp.api.registryState:
+	default:
+	}
+	select {
+	case atp.api.registryState <- state:
+	default:
+	}
+}
+
+// registryRoutine registers atp with atp.opts.Registry and
+// re-announces it every atp.opts.RegistryInterval or whenever
+// publishRegistryState reports a new State, whichever comes first. It
+// deregisters and returns supervisor.ErrDone once ctx is cancelled. A
+// nil Registry makes it a no-op.
+func (atp *Atp) registryRoutine(ctx context.Context) error {
+	reg := atp.opts.Registry
+	if reg == nil {
+		return supervisor.ErrDone
+	}
+
+	state := On
+	announce := func() {
+		err := reg.Register(registry.Registration{
+			TrainID:     atp.trainID,
+			RouteHash:   atp.routeHash,
+			SensorsAddr: atp.opts.SensorsAddr,
+			Health:      healthFor(state),
+		})
+		if err != nil {
+			log.Warning(fmt.Sprintf("%+v", err))
+		}
+	}
+	announce()
+
+	reannounce := make(chan struct{}, 1)
+	timer := time.AfterFunc(atp.opts.RegistryInterval, func() {
+		select {
+		case reannounce <- struct{}{}:
+		default:
+		}
+	})
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := reg.Deregister(atp.trainID); err != nil {
+				log.Warning(fmt.Sprintf("%+v", err))
+			}
+			return supervisor.ErrDone
+		case state = <-atp.api.registryState:
+			announce()
+			timer.Reset(atp.opts.RegistryInterval)
+		case <-reannounce:
+			announce()
+			timer.Reset(atp.opts.RegistryInterval)
+		}
+	}
+}
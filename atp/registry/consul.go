@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ConsulRegistry registers against a Consul agent's local HTTP API
+// (https://developer.hashicorp.com/consul/api-docs/agent/service),
+// using a TTL check so Health is reported without Consul needing to
+// reach the train directly.
+type ConsulRegistry struct {
+	// Addr is the Consul agent's base URL, e.g. "http://localhost:8500".
+	Addr string
+	// Client is used for every request; defaults to http.DefaultClient
+	// when nil.
+	Client *http.Client
+}
+
+// Register implements Registry.
+func (c ConsulRegistry) Register(reg Registration) error {
+	body, err := json.Marshal(consulService{
+		ID:   serviceID(reg.TrainID),
+		Name: "train",
+		Meta: map[string]string{
+			"routeHash": reg.RouteHash,
+			"sensors":   reg.SensorsAddr,
+		},
+		Check: &consulCheck{
+			TTL:    "30s",
+			Status: string(reg.Health),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.put("/v1/agent/service/register", body)
+}
+
+// Deregister implements Registry.
+func (c ConsulRegistry) Deregister(trainID int) error {
+	return c.put("/v1/agent/service/deregister/"+serviceID(trainID), nil)
+}
+
+func (c ConsulRegistry) put(path string, body []byte) error {
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequest(http.MethodPut, c.Addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("registry: %s returned status %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+func serviceID(trainID int) string {
+	return "train-" + strconv.Itoa(trainID)
+}
+
+type consulService struct {
+	ID    string            `json:"ID"`
+	Name  string            `json:"Name"`
+	Meta  map[string]string `json:"Meta,omitempty"`
+	Check *consulCheck      `json:"Check,omitempty"`
+}
+
+type consulCheck struct {
+	TTL    string `json:"TTL"`
+	Status string `json:"Status"`
+}
@@ -0,0 +1,39 @@
+package registry
+
+import "sync"
+
+// MemRegistry is an in-process Registry, useful for tests and for
+// deployments without an external service-discovery backend.
+type MemRegistry struct {
+	mu   sync.Mutex
+	byID map[int]Registration
+}
+
+// NewMemRegistry returns an empty MemRegistry.
+func NewMemRegistry() *MemRegistry {
+	return &MemRegistry{byID: make(map[int]Registration)}
+}
+
+// Register implements Registry.
+func (r *MemRegistry) Register(reg Registration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byID[reg.TrainID] = reg
+	return nil
+}
+
+// Deregister implements Registry.
+func (r *MemRegistry) Deregister(trainID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.byID, trainID)
+	return nil
+}
+
+// Get returns the last Registration reported for trainID, if any.
+func (r *MemRegistry) Get(trainID int) (Registration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reg, ok := r.byID[trainID]
+	return reg, ok
+}
@@ -0,0 +1,32 @@
+// Package registry lets an atp.Atp register itself with an external
+// service-discovery backend, so orchestration layers (dispatch, UIs)
+// can find active trains and route around ones that are unhealthy.
+package registry
+
+// Health mirrors the three check states Consul-compatible backends
+// report a service under.
+type Health string
+
+// Health values an Atp can report about itself.
+const (
+	Passing  Health = "passing"
+	Warning  Health = "warning"
+	Critical Health = "critical"
+)
+
+// Registration describes one Atp instance as reported to a Registry.
+type Registration struct {
+	TrainID     int
+	RouteHash   string
+	SensorsAddr string
+	Health      Health
+}
+
+// Registry is implemented by any service-discovery backend an Atp can
+// register itself with. Register is called once on startup and again
+// on every re-announce with the current Health; Deregister is called
+// once, when the Atp shuts down.
+type Registry interface {
+	Register(reg Registration) error
+	Deregister(trainID int) error
+}
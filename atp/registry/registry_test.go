@@ -0,0 +1,106 @@
+package registry_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/trencat/train/atp/registry"
+)
+
+func TestMemRegistry(t *testing.T) {
+	reg := registry.NewMemRegistry()
+
+	if _, ok := reg.Get(1); ok {
+		t.Fatalf("Got registration before Register was ever called")
+	}
+
+	want := registry.Registration{TrainID: 1, RouteHash: "abc", SensorsAddr: "localhost:9000", Health: registry.Passing}
+	if err := reg.Register(want); err != nil {
+		t.Fatalf("Register returned error %+v", err)
+	}
+
+	got, ok := reg.Get(1)
+	if !ok || got != want {
+		t.Errorf("Got %+v, %v, expected %+v, true", got, ok, want)
+	}
+
+	if err := reg.Deregister(1); err != nil {
+		t.Fatalf("Deregister returned error %+v", err)
+	}
+	if _, ok := reg.Get(1); ok {
+		t.Errorf("Got registration after Deregister")
+	}
+}
+
+func TestConsulRegistryRegister(t *testing.T) {
+	var gotMethod, gotPath string
+	var gotBody map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&gotBody)
+	}))
+	defer server.Close()
+
+	reg := registry.ConsulRegistry{Addr: server.URL}
+	err := reg.Register(registry.Registration{
+		TrainID:     7,
+		RouteHash:   "deadbeef",
+		SensorsAddr: "localhost:9000",
+		Health:      registry.Warning,
+	})
+	if err != nil {
+		t.Fatalf("Register returned error %+v", err)
+	}
+
+	if gotMethod != http.MethodPut {
+		t.Errorf("Got method %s, expected PUT", gotMethod)
+	}
+	if gotPath != "/v1/agent/service/register" {
+		t.Errorf("Got path %s, expected /v1/agent/service/register", gotPath)
+	}
+	if gotBody["ID"] != "train-7" {
+		t.Errorf("Got ID %v, expected train-7", gotBody["ID"])
+	}
+	check, ok := gotBody["Check"].(map[string]interface{})
+	if !ok || check["Status"] != "warning" {
+		t.Errorf("Got Check %+v, expected Status warning", gotBody["Check"])
+	}
+}
+
+func TestConsulRegistryDeregister(t *testing.T) {
+	var gotMethod, gotPath string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotPath = r.URL.Path
+	}))
+	defer server.Close()
+
+	reg := registry.ConsulRegistry{Addr: server.URL}
+	if err := reg.Deregister(7); err != nil {
+		t.Fatalf("Deregister returned error %+v", err)
+	}
+
+	if gotMethod != http.MethodPut {
+		t.Errorf("Got method %s, expected PUT", gotMethod)
+	}
+	if gotPath != "/v1/agent/service/deregister/train-7" {
+		t.Errorf("Got path %s, expected /v1/agent/service/deregister/train-7", gotPath)
+	}
+}
+
+func TestConsulRegistryErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	reg := registry.ConsulRegistry{Addr: server.URL}
+	if err := reg.Register(registry.Registration{TrainID: 1}); err == nil {
+		t.Errorf("Got nil error, expected non-nil error on 500 response")
+	}
+}
@@ -0,0 +1,74 @@
+package atp
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/trencat/goutils/syslog"
+)
+
+// SignalOptions configures InstallSignalHandler.
+type SignalOptions struct {
+	// Reload, if non-nil, is called on SIGHUP to build a
+	// ReloadRequest from whatever configuration source the caller
+	// prefers; its result is applied via Atp.Reload. A nil Reload
+	// makes SIGHUP a no-op.
+	Reload func() ReloadRequest
+}
+
+// InstallSignalHandler wires OS signals to a's lifecycle: SIGTERM and
+// SIGINT trigger a graceful Stop (Shutdown, which waits for the train
+// to actually stop before reaching Off); a second SIGTERM or SIGINT
+// received while already shutting down instead calls a.Escalate,
+// triggering the emergency brake, so operators can force-stop a train
+// stuck trying to shut down gracefully. SIGHUP calls opts.Reload, if
+// set, and applies its result via a.Reload. SIGQUIT calls a.Kill.
+//
+// For a moving train, Escalate's own forced Alarm usually arrives no
+// sooner than shutdownRoutine's automatic Shutdown-to-Alarm escalation
+// (atp.go), which already fires on the very next tick once the train
+// is found not Stopped. The operator-facing "hit it again to force"
+// idiom is kept anyway: it matches the SIGINT/SIGTERM double-signal
+// convention operators already expect, and it is the one way to force
+// Alarm that does not depend on shutdownRoutine's own escalation logic
+// remaining in place.
+//
+// The handler is automatically uninstalled once a reaches Off, via
+// offRoutine's signal.Reset, so it does not leak between Atp
+// instances, e.g. across test runs.
+func InstallSignalHandler(a *Atp, opts SignalOptions) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT)
+
+	go func() {
+		defer signal.Stop(sigCh)
+
+		shuttingDown := false
+		for {
+			select {
+			case <-a.api.notifyOff:
+				return
+			case sig := <-sigCh:
+				switch sig {
+				case syscall.SIGTERM, syscall.SIGINT:
+					if shuttingDown {
+						a.Escalate()
+					} else {
+						shuttingDown = true
+						a.Stop()
+					}
+				case syscall.SIGHUP:
+					if opts.Reload != nil {
+						if err := a.Reload(opts.Reload()); err != nil {
+							log.Warning(fmt.Sprintf("%+v", err))
+						}
+					}
+				case syscall.SIGQUIT:
+					a.Kill()
+				}
+			}
+		}
+	}()
+}
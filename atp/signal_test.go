@@ -0,0 +1,315 @@
+package atp_test
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/trencat/train/atp"
+	"github.com/trencat/train/core"
+)
+
+// stationaryTrain, stationaryRoute and stationarySensors describe an
+// already-stopped train, so Stop() reaches Off directly (see
+// shutdownRoutine). They are self-contained (no testutils fixtures)
+// since the helper process below runs outside of `go test`.
+func stationaryTrain() core.Train {
+	return core.Train{ID: 1, Mass: 1000, MaxTraction: 50000, MaxBrake: 50000, MaxVelocity: 100}
+}
+
+func stationaryRoute() []core.Track {
+	return []core.Track{{ID: 1, Length: 1000, MaxVelocity: 100}}
+}
+
+func stationarySensors() core.Sensors {
+	return core.Sensors{TrackID: 1}
+}
+
+// movingTrain, movingRoute and movingSensors describe a train running
+// at speed with a brake weak enough relative to its mass that several
+// helperRefreshRate ticks elapse before the emergency brake reaches a
+// full stop, giving runMovingShutdownEscalateHelper a window to
+// observe Alarm before the train settles back to On.
+func movingTrain() core.Train {
+	return core.Train{ID: 1, Mass: 1000, MassFactor: 1, MaxTraction: 50000, MaxBrake: 2000, MaxVelocity: 100}
+}
+
+func movingRoute() []core.Track {
+	return []core.Track{{ID: 1, Length: 100000, MaxVelocity: 100}}
+}
+
+func movingSensors() core.Sensors {
+	return core.Sensors{TrackID: 1, Velocity: 20}
+}
+
+// TestInstallSignalHandler runs atp.InstallSignalHandler in a
+// subprocess and delivers it real OS signals via os.Process.Signal:
+// SIGHUP applies a Reload, then SIGTERM requests a graceful Stop,
+// which reaches Off directly since the train is already stationary.
+func TestInstallSignalHandler(t *testing.T) {
+	if os.Getenv("ATP_SIGNAL_TEST_HELPER") == "1" {
+		runStopSignalTestHelper()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInstallSignalHandler$")
+	cmd.Env = append(os.Environ(), "ATP_SIGNAL_TEST_HELPER=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe returned error %+v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start returned error %+v", err)
+	}
+	defer cmd.Process.Kill()
+
+	reader := bufio.NewReader(stdout)
+	readLine := func() string {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString returned error %+v", err)
+		}
+		return strings.TrimSpace(line)
+	}
+
+	if got := readLine(); got != "ready" {
+		t.Fatalf("Got line %q, expected %q", got, "ready")
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGHUP); err != nil {
+		t.Fatalf("Signal returned error %+v", err)
+	}
+	if got := readLine(); got != "reloaded" {
+		t.Fatalf("Got line %q, expected %q", got, "reloaded")
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Signal returned error %+v", err)
+	}
+	if got := readLine(); got != "off" {
+		t.Fatalf("Got line %q, expected %q", got, "off")
+	}
+
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("Wait returned error %+v, expected the helper to exit cleanly", err)
+	}
+}
+
+// TestInstallSignalHandlerKill is the same as TestInstallSignalHandler
+// but checks SIGQUIT reaches Off via Kill regardless of state, rather
+// than waiting for a graceful Stop.
+func TestInstallSignalHandlerKill(t *testing.T) {
+	if os.Getenv("ATP_SIGNAL_TEST_HELPER") == "1" {
+		runKillSignalTestHelper()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInstallSignalHandlerKill$")
+	cmd.Env = append(os.Environ(), "ATP_SIGNAL_TEST_HELPER=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe returned error %+v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start returned error %+v", err)
+	}
+	defer cmd.Process.Kill()
+
+	reader := bufio.NewReader(stdout)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString returned error %+v", err)
+	}
+	if got := strings.TrimSpace(line); got != "ready" {
+		t.Fatalf("Got line %q, expected %q", got, "ready")
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGQUIT); err != nil {
+		t.Fatalf("Signal returned error %+v", err)
+	}
+
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString returned error %+v", err)
+	}
+	if got := strings.TrimSpace(line); got != "off" {
+		t.Fatalf("Got line %q, expected %q", got, "off")
+	}
+
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("Wait returned error %+v, expected the helper to exit cleanly", err)
+	}
+}
+
+// helperRefreshRate is short relative to the fixed sleeps below, so
+// a handful of ticks elapse between sending a signal and checking its
+// effect.
+const helperRefreshRate = 50 * time.Millisecond
+
+// runStopSignalTestHelper builds an Atp, installs the signal handler
+// and reports progress on stdout for the parent test to observe as it
+// exercises SIGHUP then SIGTERM. Sensors() is checked only once, after
+// a generous fixed sleep, rather than in a tight loop, to steer clear
+// of Off's unrelated, pre-existing getSensors/notifyOff teardown race.
+func runStopSignalTestHelper() {
+	a, err := atp.New(stationaryTrain(), stationaryRoute(), stationarySensors(), atp.Options{
+		RefreshRate: helperRefreshRate,
+	})
+	if err != nil {
+		fmt.Println("error", err.Error())
+		return
+	}
+
+	reloaded := make(chan struct{}, 1)
+	atp.InstallSignalHandler(a, atp.SignalOptions{
+		Reload: func() atp.ReloadRequest {
+			timeout := 10 * time.Second
+			reloaded <- struct{}{}
+			return atp.ReloadRequest{SetpointTimeout: &timeout}
+		},
+	})
+
+	fmt.Println("ready")
+
+	<-reloaded
+	fmt.Println("reloaded")
+
+	time.Sleep(10 * helperRefreshRate)
+	if state := a.Sensors().State; state == atp.Off {
+		fmt.Println("off")
+	} else {
+		fmt.Println("state", state)
+	}
+}
+
+// runKillSignalTestHelper is the SIGQUIT counterpart of
+// runStopSignalTestHelper: no Reload is involved, so it moves straight
+// from "ready" to checking Sensors() once after a generous sleep.
+func runKillSignalTestHelper() {
+	a, err := atp.New(stationaryTrain(), stationaryRoute(), stationarySensors(), atp.Options{
+		RefreshRate: helperRefreshRate,
+	})
+	if err != nil {
+		fmt.Println("error", err.Error())
+		return
+	}
+
+	atp.InstallSignalHandler(a, atp.SignalOptions{})
+
+	fmt.Println("ready")
+
+	time.Sleep(10 * helperRefreshRate)
+	if state := a.Sensors().State; state == atp.Off {
+		fmt.Println("off")
+	} else {
+		fmt.Println("state", state)
+	}
+}
+
+// TestEscalateNoop tests that Escalate has no effect while state is
+// not Shutdown: shutdownRoutine already escalates Shutdown to Alarm
+// on its own within a single tick once the train is found moving, so
+// Escalate's own guard is only meaningfully exercised outside
+// Shutdown.
+func TestEscalateNoop(t *testing.T) {
+	a, err := atp.New(stationaryTrain(), stationaryRoute(), stationarySensors())
+	if err != nil {
+		t.Fatalf("New returned error %+v", err)
+	}
+	defer a.Kill()
+
+	a.Escalate()
+	time.Sleep(50 * time.Millisecond)
+
+	if state := a.Sensors().State; state != atp.On {
+		t.Errorf("Got state %d, expected %d", state, atp.On)
+	}
+}
+
+// TestInstallSignalHandlerEscalate runs atp.InstallSignalHandler in a
+// subprocess against a moving train: a first SIGTERM requests a
+// graceful Stop (Shutdown), and a second SIGTERM sent right after
+// calls a.Escalate. As documented on Escalate, a moving train reaches
+// Alarm regardless of the second signal, since shutdownRoutine already
+// escalates Shutdown to Alarm on the next tick that finds the train
+// not Stopped; this test exists to verify the second-SIGTERM path
+// itself still reaches Alarm, not to prove it is faster than
+// shutdownRoutine's own escalation.
+func TestInstallSignalHandlerEscalate(t *testing.T) {
+	if os.Getenv("ATP_SIGNAL_TEST_HELPER") == "1" {
+		runMovingShutdownEscalateHelper()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInstallSignalHandlerEscalate$")
+	cmd.Env = append(os.Environ(), "ATP_SIGNAL_TEST_HELPER=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe returned error %+v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start returned error %+v", err)
+	}
+	defer cmd.Process.Kill()
+
+	reader := bufio.NewReader(stdout)
+	readLine := func() string {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString returned error %+v", err)
+		}
+		return strings.TrimSpace(line)
+	}
+
+	if got := readLine(); got != "ready" {
+		t.Fatalf("Got line %q, expected %q", got, "ready")
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Signal returned error %+v", err)
+	}
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Signal returned error %+v", err)
+	}
+
+	if got := readLine(); got != "alarm" {
+		t.Fatalf("Got line %q, expected %q", got, "alarm")
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+}
+
+// runMovingShutdownEscalateHelper is the moving-train counterpart of
+// runStopSignalTestHelper: it reports "ready", then polls Sensors()
+// until it observes Alarm (the brake is weak enough relative to
+// movingTrain's mass that Alarm persists across several ticks before
+// the train actually stops, see movingTrain).
+func runMovingShutdownEscalateHelper() {
+	a, err := atp.New(movingTrain(), movingRoute(), movingSensors(), atp.Options{
+		RefreshRate: helperRefreshRate,
+	})
+	if err != nil {
+		fmt.Println("error", err.Error())
+		return
+	}
+
+	atp.InstallSignalHandler(a, atp.SignalOptions{})
+
+	fmt.Println("ready")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if state := a.Sensors().State; state == atp.Alarm {
+			fmt.Println("alarm")
+			return
+		}
+		time.Sleep(helperRefreshRate)
+	}
+	fmt.Println("state", a.Sensors().State)
+}
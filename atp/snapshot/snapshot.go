@@ -0,0 +1,55 @@
+// Package snapshot defines the versioned, binary-encoded
+// representation of an atp.Atp instance's state, used to checkpoint
+// it and later resume a closed loop across a process restart.
+package snapshot
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trencat/train/core"
+)
+
+// Version is bumped whenever Snapshot's shape changes in a way Decode
+// cannot read transparently.
+const Version = 1
+
+// Snapshot captures everything atp.Atp needs to pick up where it left
+// off: its state machine, setpoints, the Warning-to-Alarm escalation
+// clock, and core.Sensors (position, velocity, track, etc.).
+type Snapshot struct {
+	Version      int
+	State        int8
+	PrevState    int8
+	UserSetpoint core.Setpoint
+	Setpoint     core.Setpoint
+	// NextAlarm is how long remained before state escalated from
+	// Warning to Alarm when the Snapshot was taken, or zero if state
+	// was not Warning.
+	NextAlarm time.Duration
+	Sensors   core.Sensors
+}
+
+// Encode serialises s using gob.
+func Encode(s Snapshot) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode deserialises b into a Snapshot. An error is returned if b
+// was produced by an incompatible Version.
+func Decode(b []byte) (Snapshot, error) {
+	var s Snapshot
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&s); err != nil {
+		return Snapshot{}, err
+	}
+	if s.Version != Version {
+		return Snapshot{}, errors.Errorf("snapshot version %d is not supported, expected %d", s.Version, Version)
+	}
+	return s, nil
+}
@@ -0,0 +1,76 @@
+package snapshot_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/trencat/train/atp/snapshot"
+	"github.com/trencat/train/core"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	want := snapshot.Snapshot{
+		Version:      snapshot.Version,
+		State:        30,
+		PrevState:    20,
+		UserSetpoint: core.Setpoint{Value: 0.5, Time: time.Now()},
+		Setpoint:     core.Setpoint{Value: 0.5, Time: time.Now()},
+		NextAlarm:    3 * time.Second,
+		Sensors:      core.Sensors{Position: 42, Velocity: 1.5, TrackID: 7},
+	}
+
+	data, err := snapshot.Encode(want)
+	if err != nil {
+		t.Fatalf("Encode returned error %+v", err)
+	}
+
+	got, err := snapshot.Decode(data)
+	if err != nil {
+		t.Fatalf("Decode returned error %+v", err)
+	}
+
+	if got.State != want.State || got.PrevState != want.PrevState ||
+		got.NextAlarm != want.NextAlarm ||
+		got.Sensors.Position != want.Sensors.Position ||
+		got.Sensors.Velocity != want.Sensors.Velocity ||
+		got.Sensors.TrackID != want.Sensors.TrackID {
+		t.Errorf("Got %+v, expected %+v", got, want)
+	}
+}
+
+func TestDecodeVersionMismatch(t *testing.T) {
+	data, err := snapshot.Encode(snapshot.Snapshot{Version: snapshot.Version + 1})
+	if err != nil {
+		t.Fatalf("Encode returned error %+v", err)
+	}
+
+	if _, err := snapshot.Decode(data); err == nil {
+		t.Errorf("Got nil error, expected non-nil error on version mismatch")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	f, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatalf("TempFile returned error %+v", err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	store := snapshot.FileStore{Path: f.Name()}
+	want := []byte("snapshot-bytes")
+
+	if err := store.Save(want); err != nil {
+		t.Fatalf("Save returned error %+v", err)
+	}
+
+	got, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load returned error %+v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Got %q, expected %q", got, want)
+	}
+}
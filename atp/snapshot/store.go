@@ -0,0 +1,26 @@
+package snapshot
+
+import "io/ioutil"
+
+// Store persists a Snapshot's encoded bytes somewhere a later Resume
+// can read them back from, e.g. a file or an external key-value
+// store.
+type Store interface {
+	Save(data []byte) error
+}
+
+// FileStore is a Store that writes the latest Snapshot to a single
+// file, overwriting it on every Save.
+type FileStore struct {
+	Path string
+}
+
+// Save implements Store.
+func (s FileStore) Save(data []byte) error {
+	return ioutil.WriteFile(s.Path, data, 0600)
+}
+
+// Load reads back the Snapshot bytes FileStore last Saved.
+func (s FileStore) Load() ([]byte, error) {
+	return ioutil.ReadFile(s.Path)
+}
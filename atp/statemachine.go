@@ -22,20 +22,50 @@ const (
 	Off      State = 70
 )
 
+// Guard is a precondition hook invoked before a transition that
+// canSet already allows is applied. Returning a non-nil error refuses
+// the transition, letting callers attach rules canSet does not
+// encode, e.g. refusing Active while Sensors.Warnings.Any() is true.
+type Guard func(from, to State) error
+
 // statemachine is not safe! Locks must be implemented somewhere else
 type stateMachine struct {
 	state     State
 	prevState State
+	guard     Guard
 }
 
 // newState declares and initialises a state instance.
 func newStateMachine() (stateMachine, error) {
 	log.Info("New state machine initialised")
 	log.Info(fmt.Sprintf("State set to %d", On))
-	return stateMachine{
+	sm := stateMachine{
 		state:     On,
 		prevState: Init,
-	}, nil
+	}
+	sm.setGuard(terminalGuard)
+	return sm, nil
+}
+
+// terminalGuard hardens stateMachine with two invariants canSet's
+// table below does not enforce: Off is terminal, and Panic can only
+// be exited via Shutdown or Off. newStateMachine installs it on every
+// Atp, so these invariants hold at runtime, not just in tests that
+// happen to install a Guard themselves.
+func terminalGuard(from, to State) error {
+	if from == Off && to != Off {
+		return errors.Errorf("Off is terminal, cannot transition to %d", to)
+	}
+	if from == Panic && to != Panic && to != Shutdown && to != Off {
+		return errors.Errorf("Panic can only be exited via Shutdown or Off, got %d", to)
+	}
+	return nil
+}
+
+// setGuard installs g as the precondition hook for every subsequent
+// set call. Passing nil removes any previously installed Guard.
+func (sm *stateMachine) setGuard(g Guard) {
+	sm.guard = g
 }
 
 func (sm *stateMachine) canSet(to State) bool {
@@ -67,6 +97,13 @@ func (sm *stateMachine) set(to State) error {
 		return err
 	}
 
+	if sm.guard != nil {
+		if err := sm.guard(from, to); err != nil {
+			log.Warning(fmt.Sprintf("%+v", err))
+			return err
+		}
+	}
+
 	sm.prevState = sm.state
 	sm.state = to
 	log.Info(fmt.Sprintf("State set to %d", to))
@@ -0,0 +1,126 @@
+package atp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// transitionsPath is the golden table of allowed transitions used by
+// TestTransitionMatrix, keyed by state name.
+const transitionsPath = "testdata/atp_transitions.json"
+
+// stateNames maps every State canSet/set reason about (Init is
+// internal-only and excluded) to its name in transitionsPath.
+var stateNames = map[string]State{
+	"On":       On,
+	"Active":   Active,
+	"Warning":  Warning,
+	"Alarm":    Alarm,
+	"Panic":    Panic,
+	"Shutdown": Shutdown,
+	"Off":      Off,
+}
+
+func loadTransitions(t *testing.T) map[string]map[string]bool {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(transitionsPath)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	table := make(map[string]map[string]bool)
+	if err := json.Unmarshal(data, &table); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return table
+}
+
+// TestTransitionMatrix enumerates the full N x N transition matrix
+// and asserts canSet and set agree with each other and with the
+// golden table in testdata/atp_transitions.json.
+func TestTransitionMatrix(t *testing.T) {
+	table := loadTransitions(t)
+
+	for fromName, from := range stateNames {
+		row, exists := table[fromName]
+		if !exists {
+			t.Fatalf("Golden table has no row for state %s", fromName)
+		}
+
+		for toName, to := range stateNames {
+			allowed, exists := row[toName]
+			if !exists {
+				t.Fatalf("Golden table has no entry for %s -> %s", fromName, toName)
+			}
+
+			sm := stateMachine{state: from, prevState: Init}
+			if got := sm.canSet(to); got != allowed {
+				t.Errorf("canSet: %s -> %s: got %t, expected %t", fromName, toName, got, allowed)
+			}
+
+			sm = stateMachine{state: from, prevState: Init}
+			err := sm.set(to)
+			if gotOK := (err == nil); gotOK != allowed {
+				t.Errorf("set: %s -> %s: got error %v, expected allowed=%t", fromName, toName, err, allowed)
+			}
+		}
+	}
+}
+
+// TestGuard tests that a Guard can refuse a transition canSet alone
+// would allow.
+func TestGuard(t *testing.T) {
+	sm := stateMachine{state: Off, prevState: Alarm}
+	sm.setGuard(terminalGuard)
+
+	if err := sm.set(Alarm); err == nil {
+		t.Errorf("Got nil error, expected Guard to refuse Off -> Alarm")
+	}
+	if state := sm.get(); state != Off {
+		t.Errorf("Got state %d, expected Off to remain unchanged after refused transition", state)
+	}
+}
+
+// TestFuzzTransitions runs long random transition sequences, guarded
+// by terminalGuard, and checks invariants at every step: Off is
+// terminal, prevState is always the immediately previous distinct
+// state, and Panic is only exited via Shutdown or Off.
+func TestFuzzTransitions(t *testing.T) {
+	allStates := []State{On, Active, Warning, Alarm, Panic, Shutdown, Off}
+
+	for seed := 0; seed < 20; seed++ {
+		rng := rand.New(rand.NewSource(int64(seed)))
+		sm := stateMachine{state: On, prevState: Init}
+		sm.setGuard(terminalGuard)
+
+		reachedOff := false
+		for step := 0; step < 200; step++ {
+			before := sm.get()
+			to := allStates[rng.Intn(len(allStates))]
+
+			err := sm.set(to)
+
+			if reachedOff && err == nil && to != Off {
+				t.Fatalf("seed %d, step %d: Off is terminal but transitioned to %d", seed, step, to)
+			}
+
+			if before == Panic && err == nil && to != Panic && to != Shutdown && to != Off {
+				t.Fatalf("seed %d, step %d: Panic exited to %d, expected only Shutdown or Off", seed, step, to)
+			}
+
+			if err == nil && to != before {
+				if sm.prev() != before {
+					t.Fatalf("seed %d, step %d: prevState %d, expected immediately previous distinct state %d",
+						seed, step, sm.prev(), before)
+				}
+			}
+
+			if sm.get() == Off {
+				reachedOff = true
+			}
+		}
+	}
+}
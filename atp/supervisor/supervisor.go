@@ -0,0 +1,203 @@
+// Package supervisor provides a small supervision tree for
+// goroutine-based subsystems, in the spirit of suture/Erlang
+// supervisors: each subsystem is a Runnable that reports whether it
+// finished cleanly, crashed, or is still working, and the Supervisor
+// restarts crashed Runnables with a configurable backoff instead of
+// making "panic kills everything" the only available policy.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/trencat/goutils/syslog"
+)
+
+// Status reports the outcome of one Runnable invocation.
+type Status int
+
+// Supervisor reports every supervised Runnable as one of these
+// statuses after each invocation.
+const (
+	// Healthy means the Runnable returned nil; it is started again
+	// immediately, whatever its Policy (Policy only governs Panic).
+	Healthy Status = iota
+	// Done means the Runnable finished on purpose (it returned
+	// ErrDone) and must not be restarted.
+	Done
+	// Panic means the Runnable returned a non-nil, non-ErrDone error,
+	// or recovered panic; whether it restarts depends on its Policy.
+	Panic
+)
+
+// Runnable is one supervised subsystem. It must return promptly once
+// ctx is cancelled. A nil return is reported Healthy, ErrDone is
+// reported Done, anything else (including a recovered panic) is
+// reported Panic.
+type Runnable func(ctx context.Context) error
+
+// ErrDone lets a Runnable signal a clean, intentional exit that must
+// not be restarted.
+var ErrDone = fmt.Errorf("supervisor: runnable done")
+
+// RestartPolicy decides what the Supervisor does after a Runnable
+// reports Panic.
+type RestartPolicy int
+
+const (
+	// RestartPolicyRestart relaunches the Runnable after Spec.Backoff,
+	// up to Spec.MaxRestarts.
+	RestartPolicyRestart RestartPolicy = iota
+	// RestartPolicyEscalate never restarts: the first Panic invokes
+	// Supervisor.OnEscalate straight away.
+	RestartPolicyEscalate
+)
+
+// Spec configures one supervised Runnable.
+type Spec struct {
+	Name string
+	Run  Runnable
+
+	// Policy chosen for this Runnable. Defaults to RestartPolicyRestart.
+	Policy RestartPolicy
+
+	// Backoff is the delay before the first restart; it scales
+	// linearly with consecutive restarts up to MaxBackoff. Defaults
+	// to one second.
+	Backoff time.Duration
+	// MaxBackoff caps Backoff scaling. Zero means no cap.
+	MaxBackoff time.Duration
+	// MaxRestarts is how many times a RestartPolicyRestart Runnable
+	// may be restarted before the Supervisor escalates anyway. Zero
+	// means unlimited.
+	MaxRestarts int
+}
+
+// Health reports the last observed Status of one supervised Runnable.
+type Health struct {
+	Name     string
+	Status   Status
+	Restarts int
+	Err      error
+}
+
+// Supervisor runs a set of Runnables, restarting the ones whose Spec
+// asks for it, and keeps their last Health for inspection.
+type Supervisor struct {
+	// OnEscalate is invoked when a Runnable's Policy or MaxRestarts
+	// gives up on restarting it after a Panic. If nil, the Supervisor
+	// panics the goroutine that detected it, preserving the
+	// "panic kills the train" behavior as the default.
+	OnEscalate func(name string, err error)
+
+	mu     sync.Mutex
+	health map[string]Health
+}
+
+// New returns an empty Supervisor.
+func New() *Supervisor {
+	return &Supervisor{health: make(map[string]Health)}
+}
+
+// Add starts spec.Run in its own goroutine under ctx, restarting it
+// per spec.Policy until ctx is cancelled. Add returns immediately.
+func (s *Supervisor) Add(ctx context.Context, spec Spec) {
+	if spec.Backoff == 0 {
+		spec.Backoff = time.Second
+	}
+	go s.supervise(ctx, spec)
+}
+
+// Health returns a snapshot of every supervised Runnable's last
+// reported status, keyed by Spec.Name.
+func (s *Supervisor) Health() map[string]Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]Health, len(s.health))
+	for name, h := range s.health {
+		out[name] = h
+	}
+	return out
+}
+
+func (s *Supervisor) setHealth(h Health) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health[h.Name] = h
+}
+
+func (s *Supervisor) supervise(ctx context.Context, spec Spec) {
+	restarts := 0
+
+	for {
+		err := s.runOnce(ctx, spec)
+
+		if err == nil {
+			s.setHealth(Health{Name: spec.Name, Status: Healthy, Restarts: restarts})
+		} else if err == ErrDone {
+			s.setHealth(Health{Name: spec.Name, Status: Done, Restarts: restarts})
+			return
+		} else {
+			s.setHealth(Health{Name: spec.Name, Status: Panic, Restarts: restarts, Err: err})
+			log.Warning(fmt.Sprintf("supervisor: %s crashed: %+v", spec.Name, err))
+
+			giveUp := spec.Policy == RestartPolicyEscalate ||
+				(spec.MaxRestarts > 0 && restarts >= spec.MaxRestarts)
+			if giveUp {
+				s.escalate(spec.Name, err)
+				return
+			}
+			restarts++
+		}
+
+		select {
+		case <-ctx.Done():
+			s.setHealth(Health{Name: spec.Name, Status: Done, Restarts: restarts})
+			return
+		default:
+		}
+
+		if err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			s.setHealth(Health{Name: spec.Name, Status: Done, Restarts: restarts})
+			return
+		case <-time.After(backoffFor(spec, restarts)):
+		}
+	}
+}
+
+func backoffFor(spec Spec, restarts int) time.Duration {
+	wait := spec.Backoff * time.Duration(restarts)
+	if wait < spec.Backoff {
+		wait = spec.Backoff
+	}
+	if spec.MaxBackoff > 0 && wait > spec.MaxBackoff {
+		wait = spec.MaxBackoff
+	}
+	return wait
+}
+
+func (s *Supervisor) escalate(name string, err error) {
+	if s.OnEscalate != nil {
+		s.OnEscalate(name, err)
+		return
+	}
+	panic(fmt.Sprintf("supervisor: %s exhausted its restart policy: %+v", name, err))
+}
+
+// runOnce runs spec.Run once, converting a recovered panic into an error.
+func (s *Supervisor) runOnce(ctx context.Context, spec Spec) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return spec.Run(ctx)
+}
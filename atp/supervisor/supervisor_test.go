@@ -0,0 +1,160 @@
+package supervisor_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trencat/train/atp/supervisor"
+)
+
+const testBackoff = time.Millisecond
+
+// TestRestart injects a fault that fails twice then finishes with
+// ErrDone, and checks the Supervisor restarts it until it reports Done.
+func TestRestart(t *testing.T) {
+	sup := supervisor.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	attempts := 0
+	healthy := make(chan struct{})
+
+	sup.Add(ctx, supervisor.Spec{
+		Name:    "flaky",
+		Policy:  supervisor.RestartPolicyRestart,
+		Backoff: testBackoff,
+		Run: func(ctx context.Context) error {
+			mu.Lock()
+			attempts++
+			n := attempts
+			mu.Unlock()
+
+			if n <= 2 {
+				return errors.Errorf("injected fault #%d", n)
+			}
+			close(healthy)
+			return supervisor.ErrDone
+		},
+	})
+
+	select {
+	case <-healthy:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Runnable never recovered after injected faults")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	health := sup.Health()["flaky"]
+	if health.Status != supervisor.Done {
+		t.Errorf("Got status %d, expected Done", health.Status)
+	}
+	if health.Restarts < 2 {
+		t.Errorf("Got %d restarts, expected at least 2", health.Restarts)
+	}
+}
+
+// TestEscalate injects a Runnable that always fails with Policy
+// Escalate, and checks OnEscalate is invoked exactly once without
+// any restart attempt.
+func TestEscalate(t *testing.T) {
+	sup := supervisor.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	escalated := make(chan string, 1)
+	sup.OnEscalate = func(name string, err error) {
+		escalated <- name
+	}
+
+	var attempts int32
+	var mu sync.Mutex
+	sup.Add(ctx, supervisor.Spec{
+		Name:    "doomed",
+		Policy:  supervisor.RestartPolicyEscalate,
+		Backoff: testBackoff,
+		Run: func(ctx context.Context) error {
+			mu.Lock()
+			attempts++
+			mu.Unlock()
+			return errors.New("always fails")
+		},
+	})
+
+	select {
+	case name := <-escalated:
+		if name != "doomed" {
+			t.Errorf("Got escalated name %s, expected doomed", name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnEscalate was never invoked")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	got := attempts
+	mu.Unlock()
+	if got != 1 {
+		t.Errorf("Got %d attempts, expected exactly 1 (no restarts under Escalate)", got)
+	}
+}
+
+// TestMaxRestarts checks that a Runnable with Policy Restart still
+// escalates once it exhausts MaxRestarts.
+func TestMaxRestarts(t *testing.T) {
+	sup := supervisor.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	escalated := make(chan struct{})
+	sup.OnEscalate = func(name string, err error) {
+		close(escalated)
+	}
+
+	sup.Add(ctx, supervisor.Spec{
+		Name:        "limited",
+		Policy:      supervisor.RestartPolicyRestart,
+		Backoff:     testBackoff,
+		MaxRestarts: 2,
+		Run: func(ctx context.Context) error {
+			return errors.New("always fails")
+		},
+	})
+
+	select {
+	case <-escalated:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnEscalate was never invoked after exhausting MaxRestarts")
+	}
+}
+
+// TestDone checks that a Runnable reporting ErrDone is not restarted.
+func TestDone(t *testing.T) {
+	sup := supervisor.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls int32
+	var mu sync.Mutex
+	sup.Add(ctx, supervisor.Spec{
+		Name:    "onceonly",
+		Backoff: testBackoff,
+		Run: func(ctx context.Context) error {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			return supervisor.ErrDone
+		},
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	got := calls
+	mu.Unlock()
+	if got != 1 {
+		t.Errorf("Got %d calls, expected exactly 1", got)
+	}
+}
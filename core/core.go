@@ -16,12 +16,20 @@ const gravity float64 = 9.80665
 // Core collects essential information for train automation and
 // implements train movement. Implements interfaces.Core.
 type Core struct {
-	train   Train
-	tracks  map[int]Track
-	route   []int
-	sensors Sensors
+	train            Train
+	tracks           map[int]Track
+	route            []int
+	sensors          Sensors
+	integrator       Integrator
+	heartbeatTimeout time.Duration
+	events           *EventBus
 }
 
+// defaultHeartbeatTimeout is how long UpdateSensors may go without a
+// renewed Setpoint before raising a Heartbeat alarm, unless overridden
+// with SetHeartbeatTimeout.
+const defaultHeartbeatTimeout = 5 * time.Second
+
 // Track specifications. Implements interfaces.Track
 type Track struct {
 	ID          int
@@ -53,40 +61,52 @@ type Setpoint struct {
 // Sensors contains dynamic data collected by train's sensors.
 // All values are expressed in the International System of Units.
 type Sensors struct {
-	Time          time.Time
-	Setpoint      Setpoint
-	Position      float64 // Relative to the beginning of the track
-	Velocity      float64
-	Acceleration  float64
-	TractionForce float64
-	BrakingForce  float64
-	TractionPower float64
-	BrakingPower  float64
-	Mass          float64
-	TrackID       int
-	RelPosition   float64 // Relative to the current track
-	Slope         float64
-	BendRadius    float64
-	Tunnel        bool
-	Resistance    float64 // Basic + line resistance
-	BasicRes      float64
-	SlopeRes      float64
-	CurveRes      float64
-	TunnelRes     float64
-	LineRes       float64 // slope + curve + tunnel resistance
-	NumPassengers int
-	Warnings      Warnings
-	Alarms        Warnings
+	Time              time.Time
+	Setpoint          Setpoint
+	Position          float64 // Relative to the beginning of the track
+	Velocity          float64
+	Acceleration      float64
+	Jerk              float64 // Only set by integrators that bound it, e.g. JerkLimitedIntegrator
+	TractionForce     float64
+	BrakingForce      float64
+	TractionPower     float64
+	BrakingPower      float64
+	Mass              float64
+	TrackID           int
+	RelPosition       float64 // Relative to the current track
+	Slope             float64
+	BendRadius        float64
+	Tunnel            bool
+	Resistance        float64 // Basic + line resistance
+	BasicRes          float64
+	SlopeRes          float64
+	CurveRes          float64
+	TunnelRes         float64
+	LineRes           float64 // slope + curve + tunnel resistance
+	NumPassengers     int
+	Warnings          Warnings
+	Alarms            Warnings
+	SetpointCorrected bool // true if Acceleration setpoint had to be clamped to stay within train/resistance limits
+	ReverseInhibited  bool // true if a braking setpoint was discarded because the train is already stopped
 }
 
-// New initialises a Core instance.
+// New initialises a Core instance. The AccelerationIntegrator is
+// selected by default; call SetIntegrator to pick another one. A
+// SyslogSubscriber is subscribed to the event bus by default,
+// preserving the syslog warnings UpdateSensors has always produced;
+// use Events to add further subscribers such as a RingBufferSubscriber
+// or a MetricsSubscriber.
 func New(train Train, route []Track, sensors Sensors) (Core, error) {
 	log.Info("New Core initialised")
 	core := Core{
-		train:   train,
-		tracks:  make(map[int]Track),
-		sensors: sensors,
+		train:            train,
+		tracks:           make(map[int]Track),
+		sensors:          sensors,
+		integrator:       AccelerationIntegrator{},
+		heartbeatTimeout: defaultHeartbeatTimeout,
+		events:           NewEventBus(),
 	}
+	core.events.Subscribe(SyslogSubscriber{})
 
 	if err := core.addRoute(route); err != nil {
 		return Core{}, err
@@ -95,6 +115,13 @@ func New(train Train, route []Track, sensors Sensors) (Core, error) {
 	return core, nil
 }
 
+// Events returns the EventBus Core publishes OutOfBoundsEvent,
+// HeartbeatEvent, SetpointCorrectedEvent and ReverseInhibitedEvent to
+// while updating Sensors. Use it to add Subscribers.
+func (c *Core) Events() *EventBus {
+	return c.events
+}
+
 // addRoute adds new tracks to Core memory. Already existing tracks
 // will be overwritten. An error is returned if any track's prevID or nextID
 // are inconsistent. In case of error, no tracks will be added.
@@ -142,6 +169,25 @@ func (c *Core) popRoute() {
 	c.route = c.route[1:len(c.route)]
 }
 
+// currentTrack returns the Track the train is currently on, popping
+// the route once the previous track has been fully travelled.
+func (c *Core) currentTrack() (Track, error) {
+	track, err := c.getRoute(0)
+	if err != nil {
+		return Track{}, err
+	}
+
+	if c.sensors.RelPosition > track.Length {
+		c.popRoute()
+		track, err = c.getRoute(0)
+		if err != nil {
+			return Track{}, err
+		}
+	}
+
+	return track, nil
+}
+
 // Sensors return current sensors values
 func (c *Core) Sensors() Sensors {
 	return c.sensors
@@ -174,220 +220,86 @@ func (c *Core) SetRoute(route []Track) error {
 	return nil
 }
 
-// UpdateSensors is a wrapper around core.UpdateSensorsAcceleration. In the future,
-// this method will choose between more than one UpdateSensors imlementations.
-func (c *Core) UpdateSensors(sp Setpoint, until time.Time) (Sensors, error) {
-	return c.UpdateSensorsAcceleration(sp, until)
+// SetIntegrator selects, by name, which Integrator implementation
+// UpdateSensors delegates to. Supported names are "acceleration"
+// (default), "velocity", "jerk" and "rk4". An error is returned and
+// the current integrator is left untouched if name is unknown.
+func (c *Core) SetIntegrator(name string) error {
+	integrator, exists := integrators[name]
+	if !exists {
+		return errors.Errorf("Unknown integrator %q", name)
+	}
+	c.integrator = integrator
+	return nil
 }
 
-// UpdateSensorsAcceleration updates real time data until a given time.
-// Setpoint argument refers to acceleration.
-func (c *Core) UpdateSensorsAcceleration(sp Setpoint, until time.Time) (Sensors, error) {
-	//TODO: Remove hardcoded constants
-	//TODO: Watch out, many log errors may happen.
+// SetHeartbeatTimeout changes how long UpdateSensors may go without a
+// renewed Setpoint before raising a Heartbeat alarm. The default,
+// applied by New, is 5 seconds.
+func (c *Core) SetHeartbeatTimeout(timeout time.Duration) {
+	c.heartbeatTimeout = timeout
+}
 
-	prev := &c.sensors
-	new := Sensors{}
-	train := &c.train
+// UpdateSensors advances the train's Sensors until the given time
+// using whichever Integrator was selected via SetIntegrator (the
+// AccelerationIntegrator by default).
+func (c *Core) UpdateSensors(sp Setpoint, until time.Time) (Sensors, error) {
+	return c.updateSensors(c.integrator, sp, until)
+}
 
-	warnings := Warnings{}
-	alarms := Warnings{}
+// UpdateSensorsAcceleration updates real time data until a given time,
+// always using AccelerationIntegrator regardless of the integrator
+// selected via SetIntegrator. Setpoint argument refers to acceleration.
+func (c *Core) UpdateSensorsAcceleration(sp Setpoint, until time.Time) (Sensors, error) {
+	return c.updateSensors(AccelerationIntegrator{}, sp, until)
+}
 
-	// Track
-	track, err := c.getRoute(0)
+func (c *Core) updateSensors(integrator Integrator, sp Setpoint, until time.Time) (Sensors, error) {
+	track, err := c.currentTrack()
 	if err != nil {
 		return Sensors{}, err
 	}
 
-	// Update track, trackIndex
-	beginNewTrack := (prev.RelPosition > track.Length)
-	if beginNewTrack {
-		c.popRoute()
-		track, err = c.getRoute(0)
-		if err != nil {
-			return Sensors{}, err
-		}
-	}
-
-	// TrackID
-	new.TrackID = track.ID
-
-	// Time
-	deltaSec := until.Sub(prev.Time).Seconds()
-	new.Time = until
-
-	// Number of passengers
-	new.NumPassengers = prev.NumPassengers
-
-	// Mass (add average mass for each passenger)
-	// TODO: Remove hardcoded mass 70
-	new.Mass = c.train.Mass + float64(new.NumPassengers)*70
-
-	// Setpoint
-	new.Setpoint = sp
-
-	// Velocity
-	new.Velocity = math.Max(0.0, prev.Velocity+deltaSec*prev.Acceleration)
-	if new.Velocity > c.train.MaxVelocity {
-		log.Warning(fmt.Sprintf("Current velocity %fm/s exceeds maximum train velocity %fm/s", new.Velocity, train.MaxVelocity))
-		err := warnings.Append(OutOfBounds{Type: VelocityError, Max: c.train.MaxVelocity, Value: new.Velocity})
-		if err != nil {
-			log.Warning(fmt.Sprintf("%+v", err))
-			return Sensors{}, err
-		}
-	}
-	if new.Velocity > track.MaxVelocity {
-		log.Warning(fmt.Sprintf("Current velocity %fm/s exceeds maximum track velocity %fm/s", new.Velocity, track.MaxVelocity))
-		err = warnings.Append(OutOfBounds{Type: VelocityError, Max: track.MaxVelocity, Value: new.Velocity})
-		if err != nil {
-			log.Warning(fmt.Sprintf("%+v", err))
-			return Sensors{}, err
-		}
-	}
-
-	// Position
-	new.Position = prev.Position + 0.5*(prev.Velocity+new.Velocity)*deltaSec
-
-	// Relative position
-	if beginNewTrack {
-		new.RelPosition = 0.5 * (prev.Velocity + new.Velocity) * deltaSec
-	} else {
-		new.RelPosition = prev.RelPosition + 0.5*(prev.Velocity+new.Velocity)*deltaSec
-	}
-
-	// Slope
-	new.Slope = track.Slope
-
-	// Bend Radius
-	new.BendRadius = track.BendRadius
-
-	// Tunnel
-	new.Tunnel = track.Tunnel
-
-	// Slope resistance
-	new.SlopeRes = new.Mass * gravity * math.Sin(new.Slope)
-
-	// Basic resistance does not exist if train is a zero slope track
-	// TODO: Add some tolerance.
-	if new.Slope != 0.0 || new.Velocity != 0.0 {
-		new.BasicRes = new.Mass * (train.ResistanceLin + train.ResistanceQua*new.Velocity*new.Velocity)
-	}
-
-	// Curve resistance only applies if train is moving
-	if new.Velocity != 0.0 {
-		if track.BendRadius <= 100 {
-			// TODO: Why this value 100?
-			// Prompt an Alert here? Danger!
-		} else if track.BendRadius < 300 {
-			new.CurveRes = 4.91 * new.Mass / (new.BendRadius - 55)
-		} else {
-			new.CurveRes = 6.3 * new.Mass / (new.BendRadius - 55)
-		}
-	}
-
-	// Tunnel resistance
-	if track.Tunnel {
-		new.TunnelRes = 1.296 * 1e-9 * math.Max(track.Length-new.RelPosition, 0.0) * gravity * new.Velocity * new.Velocity
+	prev := c.sensors
+	new, err := integrator.Step(prev, sp, until, c.train, track, c.heartbeatTimeout)
+	if err != nil {
+		return Sensors{}, err
 	}
 
-	// Line resistance
-	new.LineRes = new.SlopeRes + new.CurveRes + new.TunnelRes
-	new.Resistance = new.BasicRes + new.LineRes
-
-	// Acceleration
-	maxAcceleration := (train.MaxTraction - new.Resistance) / (new.Mass * train.MassFactor)
-	maxDeceleration := ((-1)*train.MaxBrake - new.Resistance) / (new.Mass * train.MassFactor)
-	setpoint := sp.Value
-	if setpoint > 0.0 && setpoint > maxAcceleration {
-		log.Warning(fmt.Sprintf("Acceleration setpoint %fm/s2 exceeds maximum acceleration %fm/s2. Correction required", setpoint, maxAcceleration))
-		err := warnings.Append(OutOfBounds{Type: AccelerationError, Min: maxDeceleration, Max: maxAcceleration, Value: setpoint})
-		if err != nil {
-			log.Warning(fmt.Sprintf("%+v", err))
-			return Sensors{}, err
-		}
-		new.Acceleration = maxAcceleration
-
-	} else if setpoint < 0.0 && setpoint < maxDeceleration {
-		// Case setpoint being emergency brake not considered as a warning
-		if setpoint != math.Inf(-1) {
-			log.Warning(fmt.Sprintf("Deceleration setpoint %fm/s2 exceeds maximum deceleration %fm/s2. Correction required", setpoint, maxDeceleration))
-			err := warnings.Append(OutOfBounds{Type: AccelerationError, Min: maxDeceleration, Max: maxAcceleration, Value: setpoint})
-			if err != nil {
-				log.Warning(fmt.Sprintf("%+v", err))
-				return Sensors{}, err
-			}
-		}
-		new.Acceleration = maxDeceleration
-	} else {
-		// Setpoint within limits
-		new.Acceleration = setpoint
-	}
-	if setpoint < 0.0 && new.Velocity < 0.01 { // TODO: Remove  0.01 hardcode
-		// Reverse gear not allowed.
-		new.Acceleration = 0
-		new.Velocity = 0
-	}
+	c.publishEvents(prev, sp, new)
 
-	// Reverse
-	if new.Velocity == 0.01 && new.Acceleration < 0.0 {
-		new.Acceleration = 0.0
-	}
+	c.sensors = new
+	return new, nil
+}
 
-	// Force & power
-	force := new.Mass*train.MassFactor*new.Acceleration + new.Resistance
-	if force >= 0 {
-		if force > train.MaxTraction {
-			log.Warning(fmt.Sprintf("Traction force %fN exceeds maximum traction force %fN. Correction required", force, train.MaxTraction))
-			err := warnings.Append(OutOfBounds{Type: ForceError, Max: train.MaxTraction, Value: force})
-			if err != nil {
-				log.Warning(fmt.Sprintf("%+v", err))
-				return Sensors{}, err
-			}
-			force = train.MaxTraction
-		}
-		new.TractionForce = force
-		new.TractionPower = new.TractionForce * new.Velocity
-		new.BrakingForce = 0
-		new.BrakingPower = 0
-
-	} else {
-		if -force > train.MaxBrake {
-			log.Warning(fmt.Sprintf("Braking force %fN exceeds maximum braking force %fN. Correction required", -force, train.MaxBrake))
-			err := warnings.Append(OutOfBounds{Type: ForceError, Max: train.MaxBrake, Value: -force})
-			if err != nil {
-				log.Warning(fmt.Sprintf("%+v", err))
-				return Sensors{}, err
-			}
-			force = train.MaxBrake
-		}
-		new.TractionForce = 0
-		new.TractionPower = 0
-		new.BrakingForce = -force
-		new.BrakingPower = new.BrakingForce * new.Velocity
+// publishEvents translates the structured warnings/alarms an
+// Integrator recorded on new into Events on c.events, so subscribers
+// no longer need to re-parse Sensors.Warnings themselves.
+func (c *Core) publishEvents(prev Sensors, sp Setpoint, new Sensors) {
+	for _, out := range new.Warnings.OutOfBounds {
+		c.events.Publish(OutOfBoundsEvent{OutOfBounds: out})
 	}
-
-	// Check Heartbeat
-	// TODO: Remove hardcoded duration
-	updateTimeout := time.Duration(5) * time.Second
-	setpointElapsed := until.Sub(prev.Setpoint.Time)
-	if setpointElapsed >= updateTimeout {
-		alarms.Append(Heartbeat{
-			LastTime:  sp.Time,
-			Threshold: updateTimeout})
+	for _, hb := range new.Alarms.Heartbeat {
+		c.events.Publish(HeartbeatEvent{Heartbeat: hb})
 	}
-
-	if warnings.Any() {
-		new.Warnings = warnings
+	if new.SetpointCorrected {
+		c.events.Publish(SetpointCorrectedEvent{Requested: sp.Value, Corrected: new.Acceleration})
 	}
-
-	if alarms.Any() {
-		new.Alarms = alarms
+	if new.ReverseInhibited {
+		c.events.Publish(ReverseInhibitedEvent{Velocity: prev.Velocity})
 	}
+}
 
-	// Update
-	c.sensors = new
-
-	return new, nil
+// ReportHeartbeatFailure lets an external observer, such as a
+// transport layer that pings clients independently of UpdateSensors,
+// feed a connectivity failure directly into the Heartbeat alarm.
+// lastContact is the last time that observer confirmed the peer was
+// responsive, recorded on the resulting Heartbeat exactly as
+// UpdateSensors's own elapsed-time check would.
+func (c *Core) ReportHeartbeatFailure(lastContact time.Time) {
+	hb := Heartbeat{LastTime: lastContact, Threshold: c.heartbeatTimeout}
+	c.sensors.Alarms.Append(hb)
+	c.events.Publish(HeartbeatEvent{Heartbeat: hb})
 }
 
 // EmergencyBrakeSetpoint returns the setpoint that activates emergency brakes.
@@ -7,9 +7,11 @@ import (
 	"log/syslog"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	log "github.com/trencat/goutils/syslog"
+	"github.com/trencat/train/core"
 	"github.com/trencat/train/testutils"
 )
 
@@ -70,6 +72,153 @@ func TestUpdateSensorsAcceleration(t *testing.T) {
 	}
 }
 
+// TestIntegrators smoke-tests every registered Integrator against
+// every scenario, checking that UpdateSensors runs without error and
+// the train does not teleport (Position is monotonically
+// non-decreasing) regardless of which integrator is selected.
+func TestIntegrators(t *testing.T) {
+	integratorNames := []string{"acceleration", "velocity", "jerk", "rk4"}
+	testdata := testutils.GetScenarios(t)
+
+	for _, name := range integratorNames {
+		for alias, scenario := range testdata {
+			scenario.Integrator = name
+			co := testutils.NewCore(scenario, t)
+
+			before := co.Sensors()
+			newSensors, err := co.UpdateSensors(scenario.Sensors.Setpoint, scenario.Sensors.Time.Add(1e9))
+			if err != nil {
+				t.Errorf("Integrator %s, scenario %s: Got error %+v, Expected nil", name, alias, err)
+				continue
+			}
+
+			if newSensors.Position < before.Position {
+				t.Errorf("Integrator %s, scenario %s: Got Position %f, Expected >= %f",
+					name, alias, newSensors.Position, before.Position)
+			}
+		}
+	}
+}
+
+// TestIntegratorBehavior asserts the distinct numeric behavior each
+// Integrator documents, rather than only that it runs without error:
+// AccelerationIntegrator holds the requested acceleration,
+// VelocityIntegrator stays within a bounded band around the requested
+// velocity, and
+// RK4Integrator matches forward-Euler exactly when resistance does
+// not vary with velocity (constant net acceleration collapses all
+// four RK4 stages to the same value).
+func TestIntegratorBehavior(t *testing.T) {
+	train := core.Train{
+		Mass: 40000, MassFactor: 1.05, MaxTraction: 200000, MaxBrake: 250000,
+		MaxVelocity: 30, ResistanceLin: 0.1, ResistanceQua: 0,
+	}
+	track := core.Track{ID: 1, Length: 5000, MaxVelocity: 30, BendRadius: 50}
+	now := time.Now()
+	prev := core.Sensors{Time: now, TrackID: 1, Velocity: 5, Acceleration: 0.2}
+	until := now.Add(time.Second)
+
+	t.Run("acceleration holds the requested setpoint", func(t *testing.T) {
+		sp := core.Setpoint{Value: 0.5, Time: now}
+		got, err := core.AccelerationIntegrator{}.Step(prev, sp, until, train, track, time.Hour)
+		if err != nil {
+			t.Fatalf("Step returned error %+v", err)
+		}
+		if got.Acceleration != sp.Value {
+			t.Errorf("Got Acceleration %f, expected the unclamped setpoint %f", got.Acceleration, sp.Value)
+		}
+	})
+
+	t.Run("velocity settles around the requested target", func(t *testing.T) {
+		// VelocityIntegrator derives each tick's acceleration from
+		// the previous tick's velocity, and that acceleration only
+		// moves the velocity reported one tick later, so the train
+		// overshoots and settles into a bounded oscillation around
+		// target rather than converging to it exactly. This asserts
+		// the oscillation stays bounded, not that it disappears.
+		target := 8.0
+		sp := core.Setpoint{Value: target, Time: now}
+
+		cur := prev
+		at := now
+		const maxOvershoot = 1.0
+		for i := 0; i < 20; i++ {
+			at = at.Add(100 * time.Millisecond)
+			next, err := core.VelocityIntegrator{}.Step(cur, sp, at, train, track, time.Hour)
+			if err != nil {
+				t.Fatalf("Step %d returned error %+v", i, err)
+			}
+			cur = next
+
+			if i >= 10 {
+				if diff := cur.Velocity - target; diff > maxOvershoot || diff < -maxOvershoot {
+					t.Errorf("Step %d: Got Velocity %f, expected it within %f of target %f",
+						i, cur.Velocity, maxOvershoot, target)
+				}
+			}
+		}
+	})
+
+	t.Run("rk4 matches forward-Euler under constant resistance", func(t *testing.T) {
+		// ResistanceQua is 0 and BendRadius is small enough that
+		// resistance (and so net acceleration) does not vary with
+		// velocity: all four RK4 stages then evaluate to the same
+		// acceleration, collapsing RK4's weighted average to plain
+		// v0 + deltaSec*acceleration.
+		sp := core.Setpoint{Value: 0.5, Time: now}
+		want := prev.Velocity + sp.Value
+
+		got, err := core.RK4Integrator{}.Step(prev, sp, until, train, track, time.Hour)
+		if err != nil {
+			t.Fatalf("RK4Integrator.Step returned error %+v", err)
+		}
+		if diff := got.Velocity - want; diff > 1e-6 || diff < -1e-6 {
+			t.Errorf("Got Velocity %f, expected %f (forward-Euler, constant resistance)", got.Velocity, want)
+		}
+	})
+}
+
+// TestJerkLimitedIntegratorForcePower tests that when
+// JerkLimitedIntegrator clamps Acceleration to the jerk bound, the
+// reported TractionForce/Power and BrakingForce/Power are recomputed
+// from the clamped Acceleration rather than left over from the
+// unclamped one AccelerationIntegrator.Step derived first.
+func TestJerkLimitedIntegratorForcePower(t *testing.T) {
+	train := core.Train{
+		Mass: 40000, MassFactor: 1.05, MaxTraction: 200000, MaxBrake: 250000,
+		MaxVelocity: 30, ResistanceLin: 0.1, ResistanceQua: 0.001,
+	}
+	track := core.Track{ID: 1, Length: 5000, MaxVelocity: 30, BendRadius: 2000}
+	now := time.Now()
+	prev := core.Sensors{Time: now, TrackID: 1, Velocity: 0, Acceleration: 0}
+	sp := core.Setpoint{Value: 1.0, Time: now}
+	until := now.Add(time.Second)
+
+	unclamped, err := core.AccelerationIntegrator{}.Step(prev, sp, until, train, track, time.Hour)
+	if err != nil {
+		t.Fatalf("AccelerationIntegrator.Step returned error %+v", err)
+	}
+
+	jerkLimit := 0.5
+	got, err := core.JerkLimitedIntegrator{JerkLimit: jerkLimit}.Step(prev, sp, until, train, track, time.Hour)
+	if err != nil {
+		t.Fatalf("JerkLimitedIntegrator.Step returned error %+v", err)
+	}
+
+	if got.Acceleration >= unclamped.Acceleration {
+		t.Fatalf("Got Acceleration %f, expected it clamped below unclamped Acceleration %f",
+			got.Acceleration, unclamped.Acceleration)
+	}
+
+	netForce := got.TractionForce - got.BrakingForce
+	wantNetForce := got.Mass*train.MassFactor*got.Acceleration + got.Resistance
+	if diff := netForce - wantNetForce; diff > 1e-6 || diff < -1e-6 {
+		t.Errorf("Got net force %f (TractionForce %f, BrakingForce %f) inconsistent with "+
+			"clamped Acceleration %f, expected net force %f",
+			netForce, got.TractionForce, got.BrakingForce, got.Acceleration, wantNetForce)
+	}
+}
+
 // TestUpdateScenarios used only for internal purposes.
 func TestUpdateScenarios(t *testing.T) {
 	testdata := testutils.GetScenarios(t)
@@ -0,0 +1,84 @@
+package core
+
+// EventType identifies the kind of Event published on an EventBus.
+type EventType string
+
+// Event types published by Core while updating Sensors.
+const (
+	OutOfBoundsEventType       EventType = "OutOfBounds"
+	HeartbeatEventType         EventType = "Heartbeat"
+	SetpointCorrectedEventType EventType = "SetpointCorrected"
+	ReverseInhibitedEventType  EventType = "ReverseInhibited"
+)
+
+// Event is emitted on an EventBus whenever UpdateSensors records
+// something a subscriber may care about.
+type Event interface {
+	Type() EventType
+}
+
+// OutOfBoundsEvent is published for every OutOfBounds warning
+// UpdateSensors records, e.g. velocity or force exceeding a limit.
+type OutOfBoundsEvent struct {
+	OutOfBounds OutOfBounds
+}
+
+// Type implements Event.
+func (OutOfBoundsEvent) Type() EventType { return OutOfBoundsEventType }
+
+// HeartbeatEvent is published when UpdateSensors records a Heartbeat
+// alarm because no setpoint has been received in time.
+type HeartbeatEvent struct {
+	Heartbeat Heartbeat
+}
+
+// Type implements Event.
+func (HeartbeatEvent) Type() EventType { return HeartbeatEventType }
+
+// SetpointCorrectedEvent is published when a requested acceleration
+// setpoint had to be clamped to stay within train/resistance limits.
+type SetpointCorrectedEvent struct {
+	Requested float64
+	Corrected float64
+}
+
+// Type implements Event.
+func (SetpointCorrectedEvent) Type() EventType { return SetpointCorrectedEventType }
+
+// ReverseInhibitedEvent is published when a braking setpoint was
+// discarded because the train is already stopped (reverse gear is
+// not supported).
+type ReverseInhibitedEvent struct {
+	Velocity float64
+}
+
+// Type implements Event.
+func (ReverseInhibitedEvent) Type() EventType { return ReverseInhibitedEventType }
+
+// Subscriber receives Events published on an EventBus.
+type Subscriber interface {
+	Notify(Event)
+}
+
+// EventBus fans every published Event out to its Subscribers, in the
+// order they were registered with Subscribe.
+type EventBus struct {
+	subscribers []Subscriber
+}
+
+// NewEventBus returns an EventBus with no Subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe registers s to receive every Event published afterwards.
+func (b *EventBus) Subscribe(s Subscriber) {
+	b.subscribers = append(b.subscribers, s)
+}
+
+// Publish fans e out to every registered Subscriber.
+func (b *EventBus) Publish(e Event) {
+	for _, s := range b.subscribers {
+		s.Notify(e)
+	}
+}
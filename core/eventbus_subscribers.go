@@ -0,0 +1,110 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/trencat/goutils/syslog"
+)
+
+// SyslogSubscriber logs every Event via syslog, preserving the
+// log.Warning calls that used to be inline in UpdateSensorsAcceleration.
+// Core subscribes one by default.
+type SyslogSubscriber struct{}
+
+// Notify implements Subscriber.
+func (SyslogSubscriber) Notify(e Event) {
+	switch event := e.(type) {
+	case OutOfBoundsEvent:
+		log.Warning(fmt.Sprintf("%+v", event.OutOfBounds))
+	case HeartbeatEvent:
+		log.Warning(fmt.Sprintf("%+v", event.Heartbeat))
+	case SetpointCorrectedEvent:
+		log.Warning(fmt.Sprintf("Setpoint %f corrected to %f", event.Requested, event.Corrected))
+	case ReverseInhibitedEvent:
+		log.Warning(fmt.Sprintf("Reverse gear not allowed at velocity %f", event.Velocity))
+	}
+}
+
+// RingBufferSubscriber keeps the last Capacity Events in memory, so a
+// reconnecting client (e.g. the JSON-RPC transport) can replay what
+// it missed instead of only seeing new Events.
+type RingBufferSubscriber struct {
+	Capacity int
+
+	mu   sync.Mutex
+	ring []Event
+}
+
+// Notify implements Subscriber.
+func (r *RingBufferSubscriber) Notify(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ring = append(r.ring, e)
+	if len(r.ring) > r.Capacity {
+		r.ring = r.ring[len(r.ring)-r.Capacity:]
+	}
+}
+
+// Last returns (at most) the last n Events received, oldest first.
+func (r *RingBufferSubscriber) Last(n int) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n <= 0 || n > len(r.ring) {
+		n = len(r.ring)
+	}
+	out := make([]Event, n)
+	copy(out, r.ring[len(r.ring)-n:])
+	return out
+}
+
+// MetricsSubscriber exposes Prometheus-style counters per EventType
+// and gauges for the sensor values operators usually watch.
+type MetricsSubscriber struct {
+	mu       sync.Mutex
+	counters map[EventType]int
+	gauges   map[string]float64
+}
+
+// NewMetricsSubscriber returns an empty MetricsSubscriber.
+func NewMetricsSubscriber() *MetricsSubscriber {
+	return &MetricsSubscriber{
+		counters: make(map[EventType]int),
+		gauges:   make(map[string]float64),
+	}
+}
+
+// Notify implements Subscriber.
+func (m *MetricsSubscriber) Notify(e Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[e.Type()]++
+}
+
+// ObserveSensors updates the gauges operators commonly watch. Call it
+// once per tick alongside Publish, since gauges are not derivable
+// from Events alone.
+func (m *MetricsSubscriber) ObserveSensors(sensors Sensors) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges["Velocity"] = sensors.Velocity
+	m.gauges["TractionPower"] = sensors.TractionPower
+	m.gauges["BrakingPower"] = sensors.BrakingPower
+	m.gauges["Resistance"] = sensors.Resistance
+}
+
+// Counter returns how many Events of the given EventType were observed.
+func (m *MetricsSubscriber) Counter(t EventType) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[t]
+}
+
+// Gauge returns the last value observed for the named gauge.
+func (m *MetricsSubscriber) Gauge(name string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gauges[name]
+}
@@ -0,0 +1,102 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/trencat/train/core"
+)
+
+// recordingSubscriber records every Event it receives, in order, so
+// tests can assert on fan-out order across multiple Subscribers.
+type recordingSubscriber struct {
+	events []core.Event
+}
+
+func (r *recordingSubscriber) Notify(e core.Event) {
+	r.events = append(r.events, e)
+}
+
+// TestEventBusFanOut tests that Publish delivers each Event to every
+// Subscriber, in the order they were registered with Subscribe.
+func TestEventBusFanOut(t *testing.T) {
+	bus := core.NewEventBus()
+	first := &recordingSubscriber{}
+	second := &recordingSubscriber{}
+	bus.Subscribe(first)
+	bus.Subscribe(second)
+
+	events := []core.Event{
+		core.HeartbeatEvent{},
+		core.ReverseInhibitedEvent{Velocity: 3},
+	}
+	for _, e := range events {
+		bus.Publish(e)
+	}
+
+	for _, sub := range []*recordingSubscriber{first, second} {
+		if len(sub.events) != len(events) {
+			t.Fatalf("Got %d events, expected %d", len(sub.events), len(events))
+		}
+		for i, e := range events {
+			if sub.events[i] != e {
+				t.Errorf("Got event %+v at position %d, expected %+v", sub.events[i], i, e)
+			}
+		}
+	}
+}
+
+// TestRingBufferSubscriberLast tests that RingBufferSubscriber keeps
+// only the last Capacity Events, dropping the oldest first, and that
+// Last returns them oldest first.
+func TestRingBufferSubscriberLast(t *testing.T) {
+	ring := &core.RingBufferSubscriber{Capacity: 3}
+
+	for i := 0; i < 5; i++ {
+		ring.Notify(core.ReverseInhibitedEvent{Velocity: float64(i)})
+	}
+
+	last := ring.Last(10)
+	if len(last) != 3 {
+		t.Fatalf("Got %d events, expected 3 (trimmed to Capacity)", len(last))
+	}
+	for i, want := range []float64{2, 3, 4} {
+		got := last[i].(core.ReverseInhibitedEvent).Velocity
+		if got != want {
+			t.Errorf("Got Velocity %f at position %d, expected %f", got, i, want)
+		}
+	}
+
+	if got := ring.Last(2); len(got) != 2 {
+		t.Fatalf("Got %d events, expected 2", len(got))
+	} else if got[0].(core.ReverseInhibitedEvent).Velocity != 3 {
+		t.Errorf("Got Velocity %f, expected %f", got[0].(core.ReverseInhibitedEvent).Velocity, 3.0)
+	}
+}
+
+// TestMetricsSubscriber tests that Notify counts Events per EventType
+// and that ObserveSensors updates the gauges Gauge reads back.
+func TestMetricsSubscriber(t *testing.T) {
+	m := core.NewMetricsSubscriber()
+
+	m.Notify(core.HeartbeatEvent{})
+	m.Notify(core.HeartbeatEvent{})
+	m.Notify(core.ReverseInhibitedEvent{Velocity: 1})
+
+	if got := m.Counter(core.HeartbeatEventType); got != 2 {
+		t.Errorf("Got Counter(Heartbeat) %d, expected 2", got)
+	}
+	if got := m.Counter(core.ReverseInhibitedEventType); got != 1 {
+		t.Errorf("Got Counter(ReverseInhibited) %d, expected 1", got)
+	}
+	if got := m.Counter(core.OutOfBoundsEventType); got != 0 {
+		t.Errorf("Got Counter(OutOfBounds) %d, expected 0", got)
+	}
+
+	m.ObserveSensors(core.Sensors{Velocity: 12.5, TractionPower: 1000})
+	if got := m.Gauge("Velocity"); got != 12.5 {
+		t.Errorf("Got Gauge(Velocity) %f, expected %f", got, 12.5)
+	}
+	if got := m.Gauge("TractionPower"); got != 1000 {
+		t.Errorf("Got Gauge(TractionPower) %f, expected %f", got, 1000.0)
+	}
+}
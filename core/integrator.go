@@ -0,0 +1,339 @@
+package core
+
+import (
+	"math"
+	"time"
+)
+
+// Integrator advances a train's Sensors from prev to until, given a
+// Setpoint and the Train/Track it currently runs on. heartbeatTimeout
+// is how long may elapse since sp was last renewed before a Heartbeat
+// alarm is raised. Implementations differ in what the Setpoint means
+// and how the position/velocity ODE is solved; Core.SetIntegrator
+// picks which one UpdateSensors uses.
+type Integrator interface {
+	Step(prev Sensors, sp Setpoint, until time.Time, train Train, track Track, heartbeatTimeout time.Duration) (Sensors, error)
+}
+
+// integrators holds every Integrator known to Core.SetIntegrator,
+// keyed by the name operators and tests refer to it with.
+var integrators = map[string]Integrator{
+	"acceleration": AccelerationIntegrator{},
+	"velocity":     VelocityIntegrator{},
+	"jerk":         JerkLimitedIntegrator{},
+	"rk4":          RK4Integrator{},
+}
+
+// resistance computes slope, basic, curve and tunnel resistance for a
+// train at velocity v on track, following the same model every
+// Integrator in this package uses.
+func resistance(train Train, track Track, mass, v, relPosition float64) (slopeRes, basicRes, curveRes, tunnelRes float64) {
+	slopeRes = mass * gravity * math.Sin(track.Slope)
+
+	// Basic resistance does not exist if train is a zero slope track
+	// TODO: Add some tolerance.
+	if track.Slope != 0.0 || v != 0.0 {
+		basicRes = mass * (train.ResistanceLin + train.ResistanceQua*v*v)
+	}
+
+	// Curve resistance only applies if train is moving
+	if v != 0.0 {
+		if track.BendRadius <= 100 {
+			// TODO: Why this value 100?
+			// Prompt an Alert here? Danger!
+		} else if track.BendRadius < 300 {
+			curveRes = 4.91 * mass / (track.BendRadius - 55)
+		} else {
+			curveRes = 6.3 * mass / (track.BendRadius - 55)
+		}
+	}
+
+	// Tunnel resistance
+	if track.Tunnel {
+		tunnelRes = 1.296 * 1e-9 * math.Max(track.Length-relPosition, 0.0) * gravity * v * v
+	}
+
+	return slopeRes, basicRes, curveRes, tunnelRes
+}
+
+// forceAndPower derives TractionForce/Power and BrakingForce/Power
+// from acceleration and resistance, clamping to train limits and
+// appending a warning when clamped. Shared so that an integrator
+// which revises acceleration after first computing it with
+// AccelerationIntegrator.Step (e.g. JerkLimitedIntegrator's jerk
+// bound) can recompute force/power consistently with the final
+// acceleration, following the same model every Integrator uses.
+func forceAndPower(mass, massFactor, acceleration, resistance, velocity float64, train Train, warnings *Warnings) (tractionForce, tractionPower, brakingForce, brakingPower float64) {
+	force := mass*massFactor*acceleration + resistance
+	if force >= 0 {
+		if force > train.MaxTraction {
+			warnings.Append(OutOfBounds{Type: ForceError, Max: train.MaxTraction, Value: force})
+			force = train.MaxTraction
+		}
+		return force, force * velocity, 0, 0
+	}
+
+	if -force > train.MaxBrake {
+		warnings.Append(OutOfBounds{Type: ForceError, Max: train.MaxBrake, Value: -force})
+		force = train.MaxBrake
+	}
+	return 0, 0, -force, -force * velocity
+}
+
+// AccelerationIntegrator is the original, forward-Euler integrator:
+// Setpoint.Value is interpreted directly as the requested
+// acceleration, clamped to what MaxTraction/MaxBrake allow given the
+// current resistance.
+type AccelerationIntegrator struct{}
+
+// Step implements Integrator.
+func (AccelerationIntegrator) Step(prev Sensors, sp Setpoint, until time.Time, train Train, track Track, heartbeatTimeout time.Duration) (Sensors, error) {
+	//TODO: Remove hardcoded constants
+
+	new := Sensors{}
+	warnings := Warnings{}
+	alarms := Warnings{}
+
+	beginNewTrack := prev.TrackID != track.ID
+
+	// TrackID
+	new.TrackID = track.ID
+
+	// Time
+	deltaSec := until.Sub(prev.Time).Seconds()
+	new.Time = until
+
+	// Number of passengers
+	new.NumPassengers = prev.NumPassengers
+
+	// Mass (add average mass for each passenger)
+	// TODO: Remove hardcoded mass 70
+	new.Mass = train.Mass + float64(new.NumPassengers)*70
+
+	// Setpoint
+	new.Setpoint = sp
+
+	// Velocity
+	new.Velocity = math.Max(0.0, prev.Velocity+deltaSec*prev.Acceleration)
+	if new.Velocity > train.MaxVelocity {
+		warnings.Append(OutOfBounds{Type: VelocityError, Max: train.MaxVelocity, Value: new.Velocity})
+	}
+	if new.Velocity > track.MaxVelocity {
+		warnings.Append(OutOfBounds{Type: VelocityError, Max: track.MaxVelocity, Value: new.Velocity})
+	}
+
+	// Position
+	new.Position = prev.Position + 0.5*(prev.Velocity+new.Velocity)*deltaSec
+
+	// Relative position
+	if beginNewTrack {
+		new.RelPosition = 0.5 * (prev.Velocity + new.Velocity) * deltaSec
+	} else {
+		new.RelPosition = prev.RelPosition + 0.5*(prev.Velocity+new.Velocity)*deltaSec
+	}
+
+	// Slope, bend radius, tunnel
+	new.Slope = track.Slope
+	new.BendRadius = track.BendRadius
+	new.Tunnel = track.Tunnel
+
+	// Resistance
+	new.SlopeRes, new.BasicRes, new.CurveRes, new.TunnelRes = resistance(train, track, new.Mass, new.Velocity, new.RelPosition)
+	new.LineRes = new.SlopeRes + new.CurveRes + new.TunnelRes
+	new.Resistance = new.BasicRes + new.LineRes
+
+	// Acceleration
+	maxAcceleration := (train.MaxTraction - new.Resistance) / (new.Mass * train.MassFactor)
+	maxDeceleration := ((-1)*train.MaxBrake - new.Resistance) / (new.Mass * train.MassFactor)
+	setpoint := sp.Value
+	if setpoint > 0.0 && setpoint > maxAcceleration {
+		warnings.Append(OutOfBounds{Type: AccelerationError, Min: maxDeceleration, Max: maxAcceleration, Value: setpoint})
+		new.Acceleration = maxAcceleration
+		new.SetpointCorrected = true
+
+	} else if setpoint < 0.0 && setpoint < maxDeceleration {
+		// Case setpoint being emergency brake not considered as a warning
+		if setpoint != math.Inf(-1) {
+			warnings.Append(OutOfBounds{Type: AccelerationError, Min: maxDeceleration, Max: maxAcceleration, Value: setpoint})
+			new.SetpointCorrected = true
+		}
+		new.Acceleration = maxDeceleration
+	} else {
+		// Setpoint within limits
+		new.Acceleration = setpoint
+	}
+	if setpoint < 0.0 && new.Velocity < 0.01 { // TODO: Remove  0.01 hardcode
+		// Reverse gear not allowed.
+		new.Acceleration = 0
+		new.Velocity = 0
+		new.ReverseInhibited = true
+	}
+
+	// Reverse
+	if new.Velocity == 0.01 && new.Acceleration < 0.0 {
+		new.Acceleration = 0.0
+	}
+
+	// Force & power
+	new.TractionForce, new.TractionPower, new.BrakingForce, new.BrakingPower =
+		forceAndPower(new.Mass, train.MassFactor, new.Acceleration, new.Resistance, new.Velocity, train, &warnings)
+
+	// Check Heartbeat
+	setpointElapsed := until.Sub(prev.Setpoint.Time)
+	if setpointElapsed >= heartbeatTimeout {
+		alarms.Append(Heartbeat{
+			LastTime:  sp.Time,
+			Threshold: heartbeatTimeout})
+	}
+
+	if warnings.Any() {
+		new.Warnings = warnings
+	}
+
+	if alarms.Any() {
+		new.Alarms = alarms
+	}
+
+	return new, nil
+}
+
+// VelocityIntegrator interprets Setpoint.Value as a target velocity
+// and solves for the acceleration that would close the gap between
+// prev.Velocity and it over one step of the same length, clamped to
+// what MaxTraction/MaxBrake allow. As in AccelerationIntegrator, the
+// velocity reported at until comes from prev.Acceleration, so that
+// acceleration first moves the velocity on the next Step.
+type VelocityIntegrator struct{}
+
+// Step implements Integrator.
+func (VelocityIntegrator) Step(prev Sensors, sp Setpoint, until time.Time, train Train, track Track, heartbeatTimeout time.Duration) (Sensors, error) {
+	deltaSec := until.Sub(prev.Time).Seconds()
+
+	targetVelocity := sp.Value
+	acceleration := 0.0
+	if deltaSec > 0 {
+		acceleration = (targetVelocity - prev.Velocity) / deltaSec
+	}
+
+	// Delegate to AccelerationIntegrator, which clamps this
+	// acceleration to MaxTraction/MaxBrake given the resistance at
+	// the resulting velocity.
+	new, err := AccelerationIntegrator{}.Step(prev, Setpoint{Value: acceleration, Time: sp.Time}, until, train, track, heartbeatTimeout)
+	if err != nil {
+		return Sensors{}, err
+	}
+
+	// Keep the reported Setpoint as the velocity the caller asked
+	// for, not the acceleration internally derived from it.
+	new.Setpoint = sp
+	return new, nil
+}
+
+// JerkLimitedIntegrator behaves like AccelerationIntegrator but bounds
+// the rate of change of acceleration (jerk) to JerkLimit m/s3 for
+// passenger comfort, reporting the resulting jerk in Sensors.Jerk.
+// A zero JerkLimit disables the bound.
+type JerkLimitedIntegrator struct {
+	JerkLimit float64
+}
+
+// Step implements Integrator.
+func (ji JerkLimitedIntegrator) Step(prev Sensors, sp Setpoint, until time.Time, train Train, track Track, heartbeatTimeout time.Duration) (Sensors, error) {
+	deltaSec := until.Sub(prev.Time).Seconds()
+
+	new, err := AccelerationIntegrator{}.Step(prev, sp, until, train, track, heartbeatTimeout)
+	if err != nil {
+		return Sensors{}, err
+	}
+
+	if ji.JerkLimit > 0 && deltaSec > 0 {
+		maxDelta := ji.JerkLimit * deltaSec
+		delta := new.Acceleration - prev.Acceleration
+		clamped := false
+		if delta > maxDelta {
+			new.Acceleration = prev.Acceleration + maxDelta
+			clamped = true
+		} else if delta < -maxDelta {
+			new.Acceleration = prev.Acceleration - maxDelta
+			clamped = true
+		}
+
+		if clamped {
+			new.SetpointCorrected = true
+			// Recompute force/power so they stay consistent with the
+			// jerk-clamped acceleration rather than the one
+			// AccelerationIntegrator.Step used before the jerk bound
+			// was applied.
+			new.TractionForce, new.TractionPower, new.BrakingForce, new.BrakingPower =
+				forceAndPower(new.Mass, train.MassFactor, new.Acceleration, new.Resistance, new.Velocity, train, &new.Warnings)
+		}
+	}
+
+	if deltaSec > 0 {
+		new.Jerk = (new.Acceleration - prev.Acceleration) / deltaSec
+	}
+
+	return new, nil
+}
+
+// RK4Integrator solves the velocity/position ODE with fourth-order
+// Runge-Kutta instead of forward Euler, recomputing resistance at
+// each of the four stages. This reduces integration error at large
+// deltaSec compared to AccelerationIntegrator, at the cost of
+// evaluating the resistance model four times per Step.
+type RK4Integrator struct{}
+
+// Step implements Integrator.
+func (RK4Integrator) Step(prev Sensors, sp Setpoint, until time.Time, train Train, track Track, heartbeatTimeout time.Duration) (Sensors, error) {
+	deltaSec := until.Sub(prev.Time).Seconds()
+	mass := train.Mass + float64(prev.NumPassengers)*70
+
+	// netAcceleration returns d(velocity)/dt at velocity v, holding
+	// the requested acceleration setpoint as the forcing term and
+	// recomputing resistance at v.
+	netAcceleration := func(v float64) float64 {
+		_, basicRes, curveRes, tunnelRes := resistance(train, track, mass, v, prev.RelPosition)
+		slopeRes := mass * gravity * math.Sin(track.Slope)
+		res := basicRes + slopeRes + curveRes + tunnelRes
+
+		maxAcceleration := (train.MaxTraction - res) / (mass * train.MassFactor)
+		maxDeceleration := ((-1)*train.MaxBrake - res) / (mass * train.MassFactor)
+
+		a := sp.Value
+		if a > maxAcceleration {
+			a = maxAcceleration
+		} else if a < maxDeceleration {
+			a = maxDeceleration
+		}
+		return a
+	}
+
+	v0 := prev.Velocity
+	var v1 float64
+	if deltaSec > 0 {
+		k1 := netAcceleration(v0)
+		k2 := netAcceleration(math.Max(0.0, v0+deltaSec/2*k1))
+		k3 := netAcceleration(math.Max(0.0, v0+deltaSec/2*k2))
+		k4 := netAcceleration(math.Max(0.0, v0+deltaSec*k3))
+		v1 = math.Max(0.0, v0+deltaSec/6*(k1+2*k2+2*k3+k4))
+	} else {
+		v1 = v0
+	}
+
+	acceleration := 0.0
+	if deltaSec > 0 {
+		acceleration = (v1 - v0) / deltaSec
+	}
+
+	// Reuse AccelerationIntegrator for everything downstream of
+	// velocity (position, resistance, force/power, warnings, alarms),
+	// feeding it the acceleration RK4 derived so new.Velocity matches v1.
+	rkSensors := prev
+	rkSensors.Acceleration = acceleration
+	new, err := AccelerationIntegrator{}.Step(rkSensors, Setpoint{Value: acceleration, Time: sp.Time}, until, train, track, heartbeatTimeout)
+	if err != nil {
+		return Sensors{}, err
+	}
+	new.Setpoint = sp
+	return new, nil
+}
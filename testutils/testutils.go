@@ -32,9 +32,10 @@ type Trains map[string]core.Train
 type Routes map[string][]core.Track
 
 type Scenario struct {
-	Train   string
-	Route   string
-	Sensors core.Sensors
+	Train      string
+	Route      string
+	Sensors    core.Sensors
+	Integrator string // optional; core.AccelerationIntegrator is used when empty
 }
 
 // Scenarios represents data in testdata/scenarios.json
@@ -69,14 +70,15 @@ func init() {
 }
 
 // NewAtp returns an atp.Atp instance with train, route and
-// initial conditions set.
-func NewAtp(scenario Scenario, t *testing.T) *atp.Atp {
+// initial conditions set. opts is forwarded to atp.New; omit it to
+// use its default timers.
+func NewAtp(scenario Scenario, t *testing.T, opts ...atp.Options) *atp.Atp {
 	t.Helper()
 
 	train := GetTrain(scenario.Train, t)
 	route := GetRoute(scenario.Route, t)
 
-	Atp, err := atp.New(train, route, scenario.Sensors)
+	Atp, err := atp.New(train, route, scenario.Sensors, opts...)
 	if err != nil {
 		t.Fatalf("Cannot build atp. %+v", err)
 	}
@@ -97,6 +99,12 @@ func NewCore(scenario Scenario, t *testing.T) *core.Core {
 		t.Fatalf("Cannot build core. %+v", err)
 	}
 
+	if scenario.Integrator != "" {
+		if err := co.SetIntegrator(scenario.Integrator); err != nil {
+			t.Fatalf("Cannot set integrator %s. %+v", scenario.Integrator, err)
+		}
+	}
+
 	return &co
 }
 
@@ -0,0 +1,134 @@
+package jsonrpc
+
+import (
+	"net"
+	"time"
+
+	"github.com/cenkalti/rpc2"
+	"github.com/cenkalti/rpc2/jsonrpc"
+	"github.com/pkg/errors"
+	"github.com/trencat/train/core"
+)
+
+// Client is a JSON-RPC 2.0 connection to a Server. It registers
+// handlers for the server-initiated Sensors.stream, onWarning,
+// onAlarm and Heartbeat.Ping calls, so both peers can talk over the
+// single connection opened by Dial.
+type Client struct {
+	addr      string
+	rpc       *rpc2.Client
+	conn      net.Conn
+	onSensors func(core.Sensors)
+	onWarning func(core.Warnings)
+	onAlarm   func(core.Warnings)
+}
+
+// Dial connects to a Server listening at addr and starts serving the
+// connection so the server can push notifications immediately.
+func Dial(addr string) (*Client, error) {
+	c := &Client{addr: addr}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) connect() error {
+	conn, err := net.Dial("tcp", c.addr)
+	if err != nil {
+		return errors.Wrap(err, "jsonrpc: cannot dial server")
+	}
+
+	c.conn = conn
+	c.rpc = rpc2.NewClientWithCodec(jsonrpc.NewJSONCodec(conn))
+
+	c.rpc.Handle(notifySensorsStream, func(client *rpc2.Client, sensors *core.Sensors, reply *struct{}) error {
+		if c.onSensors != nil {
+			c.onSensors(*sensors)
+		}
+		return nil
+	})
+	c.rpc.Handle(notifyOnWarning, func(client *rpc2.Client, warnings *core.Warnings, reply *struct{}) error {
+		if c.onWarning != nil {
+			c.onWarning(*warnings)
+		}
+		return nil
+	})
+	c.rpc.Handle(notifyOnAlarm, func(client *rpc2.Client, alarms *core.Warnings, reply *struct{}) error {
+		if c.onAlarm != nil {
+			c.onAlarm(*alarms)
+		}
+		return nil
+	})
+	c.rpc.Handle(notifyHeartbeatPing, func(client *rpc2.Client, ping *time.Time, reply *struct{}) error {
+		return nil
+	})
+
+	go c.rpc.Run()
+	return nil
+}
+
+// Reconnect redials the server and requests the last n Sensors
+// snapshots via Core.Replay so OnSensors callers can resync state
+// lost while disconnected.
+func (c *Client) Reconnect(n int) ([]core.Sensors, error) {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c.Replay(n)
+}
+
+// OnSensors registers the handler invoked every time the server
+// pushes a Sensors.stream notification.
+func (c *Client) OnSensors(fn func(core.Sensors)) { c.onSensors = fn }
+
+// OnWarning registers the handler invoked whenever Server.Push is
+// given Sensors for which Warnings.Any() holds.
+func (c *Client) OnWarning(fn func(core.Warnings)) { c.onWarning = fn }
+
+// OnAlarm registers the handler invoked whenever Server.Push is given
+// Sensors for which Alarms.Any() holds.
+func (c *Client) OnAlarm(fn func(core.Warnings)) { c.onAlarm = fn }
+
+// SetRoute calls Core.SetRoute on the server.
+func (c *Client) SetRoute(route []core.Track) error {
+	var reply struct{}
+	return c.rpc.Call(methodSetRoute, setRouteArgs{Route: route}, &reply)
+}
+
+// UpdateSensors calls Core.UpdateSensors on the server.
+func (c *Client) UpdateSensors(sp core.Setpoint, until time.Time) (core.Sensors, error) {
+	var reply core.Sensors
+	err := c.rpc.Call(methodUpdateSensors, updateSensorsArgs{Setpoint: sp, Until: until}, &reply)
+	return reply, err
+}
+
+// Sensors calls Core.Sensors on the server.
+func (c *Client) Sensors() (core.Sensors, error) {
+	var reply core.Sensors
+	err := c.rpc.Call(methodSensors, struct{}{}, &reply)
+	return reply, err
+}
+
+// EmergencyBrakeSetpoint calls Core.EmergencyBrakeSetpoint on the server.
+func (c *Client) EmergencyBrakeSetpoint() (core.Setpoint, error) {
+	var reply core.Setpoint
+	err := c.rpc.Call(methodEmergencyBrakeSetpoint, struct{}{}, &reply)
+	return reply, err
+}
+
+// Replay requests the last n Sensors snapshots kept by the server's
+// replay ring buffer. Passing n<=0 requests the full backlog.
+func (c *Client) Replay(n int) ([]core.Sensors, error) {
+	var reply replayReply
+	err := c.rpc.Call(methodReplay, replayArgs{N: n}, &reply)
+	return reply.Sensors, err
+}
+
+// Close terminates the underlying connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
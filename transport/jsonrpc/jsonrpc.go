@@ -0,0 +1,80 @@
+// Package jsonrpc exposes a core.Core instance over a bidirectional
+// JSON-RPC 2.0 connection over TCP; WebSocket is not supported.
+// Unlike a plain net/rpc
+// server, both peers may register handlers on the same connection:
+// the server answers Core method calls and pushes Sensors.stream
+// notifications every tick, while the client answers callbacks the
+// server issues for warnings, alarms and heartbeat pings. This is
+// implemented on top of github.com/cenkalti/rpc2, which multiplexes
+// calls and notifications over a single net.Conn in both directions.
+package jsonrpc
+
+import (
+	"time"
+
+	"github.com/trencat/train/core"
+)
+
+// Default RPC method and notification names exposed by Server
+// and consumed by Client.
+const (
+	methodSetRoute               = "Core.SetRoute"
+	methodUpdateSensors          = "Core.UpdateSensors"
+	methodSensors                = "Core.Sensors"
+	methodEmergencyBrakeSetpoint = "Core.EmergencyBrakeSetpoint"
+	methodReplay                 = "Core.Replay"
+
+	notifySensorsStream = "Sensors.stream"
+	notifyOnWarning     = "Core.onWarning"
+	notifyOnAlarm       = "Core.onAlarm"
+	notifyHeartbeatPing = "Heartbeat.Ping"
+)
+
+// setRouteArgs are the arguments of methodSetRoute.
+type setRouteArgs struct {
+	Route []core.Track
+}
+
+// updateSensorsArgs are the arguments of methodUpdateSensors.
+type updateSensorsArgs struct {
+	Setpoint core.Setpoint
+	Until    time.Time
+}
+
+// replayArgs are the arguments of methodReplay.
+type replayArgs struct {
+	N int
+}
+
+// replayReply is the reply of methodReplay.
+type replayReply struct {
+	Sensors []core.Sensors
+}
+
+// sensorsRing is a fixed-capacity ring buffer of core.Sensors
+// snapshots, used to serve Core.Replay requests on reconnect.
+type sensorsRing struct {
+	buf []core.Sensors
+	cap int
+}
+
+func newSensorsRing(capacity int) *sensorsRing {
+	return &sensorsRing{buf: make([]core.Sensors, 0, capacity), cap: capacity}
+}
+
+func (r *sensorsRing) push(s core.Sensors) {
+	r.buf = append(r.buf, s)
+	if len(r.buf) > r.cap {
+		r.buf = r.buf[len(r.buf)-r.cap:]
+	}
+}
+
+// last returns (at most) the last n snapshots, oldest first.
+func (r *sensorsRing) last(n int) []core.Sensors {
+	if n <= 0 || n > len(r.buf) {
+		n = len(r.buf)
+	}
+	out := make([]core.Sensors, n)
+	copy(out, r.buf[len(r.buf)-n:])
+	return out
+}
@@ -0,0 +1,36 @@
+package jsonrpc
+
+import (
+	"testing"
+
+	"github.com/trencat/train/core"
+)
+
+// TestSensorsRingWraparound tests that sensorsRing keeps only the
+// last cap Sensors snapshots, dropping the oldest first, and that
+// last returns them oldest first.
+func TestSensorsRingWraparound(t *testing.T) {
+	ring := newSensorsRing(3)
+
+	for i := 0; i < 5; i++ {
+		ring.push(core.Sensors{Position: float64(i)})
+	}
+
+	got := ring.last(10)
+	if len(got) != 3 {
+		t.Fatalf("Got %d snapshots, expected 3 (trimmed to cap)", len(got))
+	}
+	for i, want := range []float64{2, 3, 4} {
+		if got[i].Position != want {
+			t.Errorf("Got Position %f at index %d, expected %f", got[i].Position, i, want)
+		}
+	}
+
+	if got := ring.last(2); len(got) != 2 || got[0].Position != 3 || got[1].Position != 4 {
+		t.Fatalf("Got %+v, expected the last 2 snapshots, oldest first", got)
+	}
+
+	if got := ring.last(0); len(got) != 3 {
+		t.Errorf("Got %d snapshots for last(0), expected the full backlog (3)", len(got))
+	}
+}
@@ -0,0 +1,205 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/rpc2"
+	"github.com/cenkalti/rpc2/jsonrpc"
+	"github.com/pkg/errors"
+	log "github.com/trencat/goutils/syslog"
+	"github.com/trencat/train/core"
+)
+
+// replayBacklog is the number of Sensors snapshots kept for clients
+// that reconnect and request a resync via Core.Replay.
+const replayBacklog = 64
+
+// heartbeatPingRate is how often the server pings each connected
+// client. A client that stops answering pings is treated the same
+// way as a stale setpoint: it feeds core.Heartbeat.
+const heartbeatPingRate = time.Duration(2) * time.Second
+
+// Server exposes a core.Core over JSON-RPC 2.0, pushing Sensors.stream
+// notifications and onWarning/onAlarm callbacks to every connected
+// client as soon as UpdateSensors records them.
+type Server struct {
+	core   *core.Core
+	rpc    *rpc2.Server
+	ring   *sensorsRing
+	ticker *time.Ticker
+	done   chan struct{}
+
+	clientsMu sync.Mutex
+	clients   map[*rpc2.Client]struct{}
+}
+
+// NewServer builds a Server around an existing core.Core. The caller
+// is still responsible for calling UpdateSensors (typically from
+// atp.Atp); Server only observes the resulting Sensors via Push.
+func NewServer(co *core.Core) *Server {
+	s := &Server{
+		core:    co,
+		rpc:     rpc2.NewServer(),
+		ring:    newSensorsRing(replayBacklog),
+		done:    make(chan struct{}),
+		clients: make(map[*rpc2.Client]struct{}),
+	}
+
+	s.rpc.Handle(methodSetRoute, s.handleSetRoute)
+	s.rpc.Handle(methodUpdateSensors, s.handleUpdateSensors)
+	s.rpc.Handle(methodSensors, s.handleSensors)
+	s.rpc.Handle(methodEmergencyBrakeSetpoint, s.handleEmergencyBrakeSetpoint)
+	s.rpc.Handle(methodReplay, s.handleReplay)
+
+	s.rpc.OnConnect(func(client *rpc2.Client) {
+		s.clientsMu.Lock()
+		s.clients[client] = struct{}{}
+		s.clientsMu.Unlock()
+		log.Info("jsonrpc: client connected")
+	})
+	s.rpc.OnDisconnect(func(client *rpc2.Client) {
+		s.clientsMu.Lock()
+		delete(s.clients, client)
+		s.clientsMu.Unlock()
+		log.Info("jsonrpc: client disconnected")
+	})
+
+	return s
+}
+
+// connectedClients returns a snapshot of the clients currently
+// connected. rpc2.Server keeps no registry of its own (no
+// ClientsByState or similar), so Server maintains one itself via
+// OnConnect/OnDisconnect.
+func (s *Server) connectedClients() []*rpc2.Client {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	clients := make([]*rpc2.Client, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Serve listens on addr (TCP) and returns once listening, accepting
+// connections in the background, one goroutine per connection, until
+// Accept fails. The listener is not exposed, and nothing in Server
+// closes it.
+func (s *Server) Serve(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "jsonrpc: cannot listen")
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.rpc.ServeCodec(jsonrpc.NewJSONCodec(conn))
+		}
+	}()
+
+	return nil
+}
+
+// Push broadcasts the latest Sensors to every connected client as a
+// Sensors.stream notification, records it in the replay ring buffer,
+// and invokes onWarning/onAlarm callbacks on the client side whenever
+// Warnings.Any() or Alarms.Any() holds. Call this once per tick, right
+// after atp/core produce a new core.Sensors value.
+func (s *Server) Push(sensors core.Sensors) {
+	s.ring.push(sensors)
+
+	for _, client := range s.connectedClients() {
+		client.Notify(notifySensorsStream, sensors)
+
+		if sensors.Warnings.Any() {
+			client.Notify(notifyOnWarning, sensors.Warnings)
+		}
+		if sensors.Alarms.Any() {
+			client.Notify(notifyOnAlarm, sensors.Alarms)
+		}
+	}
+}
+
+// StartHeartbeat pings every connected client at heartbeatPingRate. A
+// client unable to answer (connection gone, handler missing) has the
+// failure reported into core.Core's Heartbeat alarm via
+// core.Core.ReportHeartbeatFailure, in addition to the elapsed-time
+// check UpdateSensors already performs on every tick: this is what
+// catches a client that stops answering pings but keeps sending
+// Setpoints (e.g. a stuck RPC handler), which the elapsed-time check
+// alone would miss.
+func (s *Server) StartHeartbeat() {
+	s.ticker = time.NewTicker(heartbeatPingRate)
+	go func() {
+		// lastContact holds, per connected client, the last time it
+		// answered a ping; a client that never answered has none.
+		lastContact := make(map[*rpc2.Client]time.Time)
+		for {
+			select {
+			case <-s.ticker.C:
+				contact := make(map[*rpc2.Client]time.Time)
+				for _, client := range s.connectedClients() {
+					now := time.Now()
+					var reply struct{}
+					if err := client.Call(notifyHeartbeatPing, now, &reply); err != nil {
+						log.Warning(fmt.Sprintf("jsonrpc: heartbeat ping failed: %+v", err))
+						s.core.ReportHeartbeatFailure(lastContact[client])
+						contact[client] = lastContact[client]
+					} else {
+						contact[client] = now
+					}
+				}
+				lastContact = contact
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the heartbeat goroutine. It does not close the listener
+// Serve opened, so Serve's accept loop keeps running.
+func (s *Server) Close() {
+	if s.ticker != nil {
+		s.ticker.Stop()
+	}
+	close(s.done)
+}
+
+func (s *Server) handleSetRoute(client *rpc2.Client, args *setRouteArgs, reply *struct{}) error {
+	return s.core.SetRoute(args.Route)
+}
+
+func (s *Server) handleUpdateSensors(client *rpc2.Client, args *updateSensorsArgs, reply *core.Sensors) error {
+	sensors, err := s.core.UpdateSensors(args.Setpoint, args.Until)
+	if err != nil {
+		return err
+	}
+	*reply = sensors
+	return nil
+}
+
+func (s *Server) handleSensors(client *rpc2.Client, args *struct{}, reply *core.Sensors) error {
+	*reply = s.core.Sensors()
+	return nil
+}
+
+func (s *Server) handleEmergencyBrakeSetpoint(client *rpc2.Client, args *struct{}, reply *core.Setpoint) error {
+	*reply = s.core.EmergencyBrakeSetpoint()
+	return nil
+}
+
+// handleReplay lets a reconnecting client request the last N Sensors
+// snapshots so it can resync without waiting for the next tick.
+func (s *Server) handleReplay(client *rpc2.Client, args *replayArgs, reply *replayReply) error {
+	reply.Sensors = s.ring.last(args.N)
+	return nil
+}